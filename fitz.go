@@ -22,9 +22,35 @@ var (
 	ErrCreatePixmap    = errors.New("fitz: cannot create pixmap")
 	ErrPixmapSamples   = errors.New("fitz: cannot get pixmap samples")
 	ErrNeedsPassword   = errors.New("fitz: document needs password")
+	ErrWrongPassword   = errors.New("fitz: wrong password")
 	ErrLoadOutline     = errors.New("fitz: cannot load outline")
+	ErrSearch          = errors.New("fitz: cannot search page")
+	ErrTextOptions     = errors.New("fitz: cannot parse text options")
+	ErrCreateOutput    = errors.New("fitz: cannot create output")
+	ErrWriteOutput     = errors.New("fitz: cannot write output")
+	ErrCopyText        = errors.New("fitz: cannot copy text")
+	ErrPageClosed      = errors.New("fitz: page is closed")
+	ErrImageSize       = errors.New("fitz: image size does not match page")
+	ErrTileSize        = errors.New("fitz: invalid tile size")
+	ErrPartialRender   = errors.New("fitz: page rendered partially")
+	ErrRenderOptions   = errors.New("fitz: unsupported render options")
+	ErrEncodeImage     = errors.New("fitz: cannot encode image")
+	ErrImageFormat     = errors.New("fitz: unsupported image format")
+	ErrImageMissing    = errors.New("fitz: image missing")
+	ErrLayout          = errors.New("fitz: cannot layout document")
+	ErrBookmark        = errors.New("fitz: cannot resolve bookmark")
+	ErrPageLabel       = errors.New("fitz: cannot get page label")
+	ErrPageBox         = errors.New("fitz: cannot get page box")
+	ErrDocumentFormat  = errors.New("fitz: unsupported document format")
+	ErrCreateWriter    = errors.New("fitz: cannot create document writer")
+	ErrWritePage       = errors.New("fitz: cannot write page")
+	ErrWriterClosed    = errors.New("fitz: document writer is closed")
+	ErrDeviceClosed    = errors.New("fitz: device is closed")
 )
 
+// pageLabelSize is the buffer size for page labels.
+const pageLabelSize = 128
+
 // MaxStore is maximum size in bytes of the resource store, before it will start evicting cached resources such as fonts and images.
 var MaxStore = 256 << 20
 
@@ -32,6 +58,49 @@ var MaxStore = 256 << 20
 // It is also possible to set `FZ_VERSION` environment variable.
 var FzVersion = "1.28.0"
 
+// AuthResult is a bitmask describing how a password authenticated the document.
+type AuthResult int
+
+// Authentication results.
+const (
+	// AuthNoPassword is set when the document does not require a password.
+	AuthNoPassword AuthResult = 1 << iota
+	// AuthUser is set when the user password matched.
+	AuthUser
+	// AuthOwner is set when the owner password matched.
+	AuthOwner
+)
+
+// Permission is a bitmask of document permissions.
+type Permission int
+
+// Permissions.
+const (
+	PermissionPrint Permission = 1 << iota
+	PermissionCopy
+	PermissionEdit
+	PermissionAnnotate
+	PermissionForm
+	PermissionAccessibility
+	PermissionAssemble
+	PermissionPrintHQ
+)
+
+// permissions maps each Permission bit to its fz_permission code.
+var permissions = []struct {
+	flag Permission
+	code int
+}{
+	{PermissionPrint, 'p'},
+	{PermissionCopy, 'c'},
+	{PermissionEdit, 'e'},
+	{PermissionAnnotate, 'n'},
+	{PermissionForm, 'f'},
+	{PermissionAccessibility, 'y'},
+	{PermissionAssemble, 'a'},
+	{PermissionPrintHQ, 'h'},
+}
+
 // Outline type.
 type Outline struct {
 	// Hierarchy level of the entry (starting from 1).
@@ -42,13 +111,85 @@ type Outline struct {
 	URI string
 	// The page number of an internal link.
 	Page int
-	// Top.
+	// Location of an internal link, by chapter.
+	Location Location
+	// Label of the page of an internal link as returned by PageLabel, set by ToCWithLabels only.
+	Label string
+	// Position on the page of an internal link, in page points.
+	Position Point
+	// Top is the same as Position.Y.
+	//
+	// Deprecated: Use Position.Y.
 	Top float64
 }
 
 // Link type.
 type Link struct {
 	URI string
+	// Rect is the active area of the link on the page, in page points.
+	Rect Rect
+	// External reports whether URI points outside of the document.
+	External bool
+	// Dest is the resolved destination of an internal link.
+	Dest LinkDest
+}
+
+// LinkDestType is the way a link destination is fitted to the view.
+type LinkDestType int
+
+// Link destination types.
+const (
+	LinkDestFit LinkDestType = iota
+	LinkDestFitB
+	LinkDestFitH
+	LinkDestFitBH
+	LinkDestFitV
+	LinkDestFitBV
+	LinkDestFitR
+	LinkDestXYZ
+)
+
+// LinkDest type.
+type LinkDest struct {
+	// Page number in the document, -1 if the link has no internal destination.
+	Page int
+	// Chapter containing the page.
+	Chapter int
+	// Position on the page, in page points.
+	X, Y float64
+	// Zoom factor, 0 if unchanged.
+	Zoom float64
+	Type LinkDestType
+}
+
+// Location is the address of a page by chapter, as in EPUB. Documents without chapters have all pages in chapter 0.
+type Location struct {
+	Chapter int
+	Page    int
+}
+
+// Bookmark is a reading position in a reflowable document that survives Layout.
+type Bookmark int64
+
+// Info type.
+type Info struct {
+	// Document format and version, e.g. "PDF 1.7".
+	Format string
+	// Description of the encryption method, e.g. "Standard R2 V1 40-bit RC4".
+	Encryption string
+	// Encrypted is true when the document uses any encryption.
+	Encrypted bool
+	// Permissions granted by the document.
+	Permissions Permission
+
+	Title        string
+	Author       string
+	Subject      string
+	Keywords     string
+	Creator      string
+	Producer     string
+	CreationDate string
+	ModDate      string
 }
 
 func bytePtrToString(p *byte) string {
@@ -68,11 +209,11 @@ func bytePtrToString(p *byte) string {
 	return string(unsafe.Slice(p, n))
 }
 
-// cropImage returns the img region at point rectangle (x0,y0)-(x1,y1) scaled to dpi, at origin (0,0).
-func cropImage(img *image.RGBA, x0, y0, x1, y1, dpi float64) *image.RGBA {
+// cropImage returns the img region at the rectangle box in page points scaled to dpi, at origin (0,0).
+func cropImage(img *image.RGBA, box Rect, dpi float64) *image.RGBA {
 	s := dpi / 72
 
-	r := image.Rect(int(math.Round(x0*s)), int(math.Round(y0*s)), int(math.Round(x1*s)), int(math.Round(y1*s)))
+	r := image.Rect(int(math.Round(box.X0*s)), int(math.Round(box.Y0*s)), int(math.Round(box.X1*s)), int(math.Round(box.Y1*s)))
 	r = r.Intersect(img.Bounds())
 	if r.Empty() {
 		return img
@@ -0,0 +1,101 @@
+package fitz
+
+import (
+	"container/list"
+	"unsafe"
+)
+
+// MaxDisplayLists is the default number of page display lists cached by a new Document, 0 disables the cache.
+// The cache is off by default, since it keeps the recorded pages in memory until they are evicted or the document is closed.
+var MaxDisplayLists = 0
+
+// displayListCache is an LRU cache of recorded pages, bounded by the number of display lists.
+// The cache holds a reference to each list, evicted lists are returned to the caller to drop.
+type displayListCache struct {
+	capacity int
+	lru      *list.List
+	pages    map[int]*list.Element
+}
+
+type displayListEntry struct {
+	page int
+	list unsafe.Pointer
+}
+
+// get returns the cached list for page, or nil.
+func (c *displayListCache) get(page int) unsafe.Pointer {
+	e, ok := c.pages[page]
+	if !ok {
+		return nil
+	}
+
+	c.lru.MoveToFront(e)
+
+	return e.Value.(*displayListEntry).list
+}
+
+// add caches the list for page, returning the lists evicted to stay within capacity.
+func (c *displayListCache) add(page int, l unsafe.Pointer) []unsafe.Pointer {
+	if c.pages == nil {
+		c.lru = list.New()
+		c.pages = make(map[int]*list.Element)
+	}
+
+	evicted := c.remove(page)
+
+	c.pages[page] = c.lru.PushFront(&displayListEntry{page: page, list: l})
+
+	return append(evicted, c.trim()...)
+}
+
+// remove uncaches the list for page.
+func (c *displayListCache) remove(page int) []unsafe.Pointer {
+	e, ok := c.pages[page]
+	if !ok {
+		return nil
+	}
+
+	return []unsafe.Pointer{c.evict(e)}
+}
+
+// setCapacity changes the capacity, returning the lists evicted to stay within it.
+func (c *displayListCache) setCapacity(capacity int) []unsafe.Pointer {
+	c.capacity = capacity
+
+	return c.trim()
+}
+
+// clear empties the cache, returning all the lists.
+func (c *displayListCache) clear() []unsafe.Pointer {
+	var evicted []unsafe.Pointer
+	for c.len() > 0 {
+		evicted = append(evicted, c.evict(c.lru.Back()))
+	}
+
+	return evicted
+}
+
+// len returns the number of cached lists.
+func (c *displayListCache) len() int {
+	if c.lru == nil {
+		return 0
+	}
+
+	return c.lru.Len()
+}
+
+func (c *displayListCache) trim() []unsafe.Pointer {
+	var evicted []unsafe.Pointer
+	for c.len() > 0 && c.len() > c.capacity {
+		evicted = append(evicted, c.evict(c.lru.Back()))
+	}
+
+	return evicted
+}
+
+func (c *displayListCache) evict(e *list.Element) unsafe.Pointer {
+	entry := c.lru.Remove(e).(*displayListEntry)
+	delete(c.pages, entry.page)
+
+	return entry.list
+}
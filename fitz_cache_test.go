@@ -0,0 +1,100 @@
+package fitz
+
+import (
+	"path/filepath"
+	"testing"
+	"unsafe"
+)
+
+func TestDisplayListCache(t *testing.T) {
+	lists := make([]byte, 3)
+	ptr := func(i int) unsafe.Pointer { return unsafe.Pointer(&lists[i]) }
+
+	c := displayListCache{capacity: 2}
+
+	if evicted := c.add(0, ptr(0)); len(evicted) != 0 {
+		t.Errorf("add(0) evicted %d lists, want 0", len(evicted))
+	}
+
+	if evicted := c.add(1, ptr(1)); len(evicted) != 0 {
+		t.Errorf("add(1) evicted %d lists, want 0", len(evicted))
+	}
+
+	if got := c.get(0); got != ptr(0) {
+		t.Errorf("get(0) = %p, want %p", got, ptr(0))
+	}
+
+	// Page 0 was used last, so page 1 goes.
+	evicted := c.add(2, ptr(2))
+	if len(evicted) != 1 || evicted[0] != ptr(1) {
+		t.Errorf("add(2) evicted %v, want [%p]", evicted, ptr(1))
+	}
+
+	if got := c.get(1); got != nil {
+		t.Errorf("get(1) = %p, want nil", got)
+	}
+
+	if evicted := c.setCapacity(0); len(evicted) != 2 || c.len() != 0 {
+		t.Errorf("setCapacity(0) evicted %d lists, left %d, want 2 and 0", len(evicted), c.len())
+	}
+}
+
+func TestDocumentDisplayListCache(t *testing.T) {
+	doc, err := New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	if _, err := doc.ImageDPI(0, 50); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := doc.lists.len(); n != 0 {
+		t.Errorf("cached %d lists by default, want 0", n)
+	}
+
+	doc.SetDisplayListCache(1)
+
+	if _, err := doc.ImageDPI(0, 50); err != nil {
+		t.Fatal(err)
+	}
+
+	hit := doc.lists.get(0)
+	if hit == nil {
+		t.Fatal("expected page 0 to be cached")
+	}
+
+	if _, err := doc.Text(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := doc.lists.get(0); got != hit {
+		t.Errorf("page 0 recorded again, want the cached list replayed")
+	}
+
+	if doc.NumPage() > 1 {
+		if _, err := doc.Text(1); err != nil {
+			t.Fatal(err)
+		}
+
+		if doc.lists.get(0) != nil || doc.lists.get(1) == nil {
+			t.Error("expected page 0 evicted for page 1")
+		}
+	}
+
+	doc.SetDisplayListCache(0)
+
+	if n := doc.lists.len(); n != 0 {
+		t.Errorf("cached %d lists after SetDisplayListCache(0), want 0", n)
+	}
+
+	if _, err := doc.ImageDPI(0, 50); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := doc.lists.len(); n != 0 {
+		t.Errorf("cached %d lists with the cache disabled, want 0", n)
+	}
+}
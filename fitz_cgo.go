@@ -39,6 +39,19 @@ fz_document *open_document_with_stream(fz_context *ctx, const char *magic, fz_st
 	return doc;
 }
 
+int authenticate_password(fz_context *ctx, fz_document *doc, const char *password) {
+	int ret;
+
+	fz_try(ctx) {
+		ret = fz_authenticate_password(ctx, doc, password);
+	}
+	fz_catch(ctx) {
+		return 0;
+	}
+
+	return ret;
+}
+
 fz_page *load_page(fz_context *ctx, fz_document *doc, int number) {
 	fz_page *page;
 
@@ -63,362 +76,2832 @@ int run_page_contents(fz_context *ctx, fz_page *page, fz_device *dev, fz_matrix
 	return 1;
 }
 
-static void silent_warning(void *user, const char *message) {}
+int run_display_list(fz_context *ctx, fz_display_list *list, fz_device *dev, fz_matrix transform, fz_rect scissor, fz_cookie *cookie) {
+	fz_try(ctx) {
+		fz_run_display_list(ctx, list, dev, transform, scissor, cookie);
+	}
+	fz_catch(ctx) {
+		return 0;
+	}
 
-void silence_warnings(fz_context *ctx) {
-	fz_set_warning_callback(ctx, silent_warning, NULL);
+	return 1;
 }
 
-// page_info sets *dpi to the page's native image resolution; for a single full-page image with no text it also sets *box and returns 1.
-int page_info(fz_context *ctx, fz_page *page, double *dpi, fz_rect *box) {
-	fz_rect b = fz_bound_page(ctx, page);
-	double parea = (b.x1 - b.x0) * (b.y1 - b.y0);
-	fz_stext_page *text = fz_new_stext_page(ctx, b);
-	fz_stext_options opts = {0};
-	opts.flags = FZ_STEXT_PRESERVE_IMAGES;
+fz_band_writer *new_band_writer(fz_context *ctx, fz_output *out, int format, int w, int h, int n, int res, int pagenum, fz_colorspace *cs) {
+	fz_band_writer *writer = NULL;
 
-	double nd = 0, barea = 0;
-	int has_text = 0;
-	fz_rect bb = fz_empty_rect;
+	fz_var(writer);
 
 	fz_try(ctx) {
-		fz_device *dev = fz_new_stext_device(ctx, text, &opts);
-		fz_run_page(ctx, page, dev, fz_identity, NULL);
-		fz_close_device(ctx, dev);
-		fz_drop_device(ctx, dev);
-
-		for (fz_stext_block *blk = text->first_block; blk; blk = blk->next) {
-			if (blk->type == FZ_STEXT_BLOCK_TEXT) {
-				has_text = 1;
-				continue;
-			}
-			if (blk->type != FZ_STEXT_BLOCK_IMAGE)
-				continue;
-			fz_image *img = blk->u.i.image;
-			double bw = blk->bbox.x1 - blk->bbox.x0;
-			double bh = blk->bbox.y1 - blk->bbox.y0;
-			if (bw > 0 && img->w * 72.0 / bw > nd)
-				nd = img->w * 72.0 / bw;
-			if (bh > 0 && img->h * 72.0 / bh > nd)
-				nd = img->h * 72.0 / bh;
-			if (bw * bh > barea) {
-				barea = bw * bh;
-				bb = blk->bbox;
-			}
+		switch (format) {
+		case 1:
+			writer = fz_new_pam_band_writer(ctx, out);
+			break;
+		case 3:
+			writer = fz_new_pnm_band_writer(ctx, out);
+			break;
+		case 4:
+			writer = fz_new_psd_band_writer(ctx, out);
+			break;
+		default:
+			writer = fz_new_png_band_writer(ctx, out);
 		}
+		fz_write_header(ctx, writer, w, h, n, 0, res, res, pagenum, cs, NULL);
 	}
-	fz_always(ctx)
-		fz_drop_stext_page(ctx, text);
 	fz_catch(ctx) {
-		nd = 0;
-		has_text = 1;
+		fz_drop_band_writer(ctx, writer);
+		return NULL;
 	}
 
-	*dpi = nd;
-	*box = bb;
-
-	return (!has_text && parea > 0 && barea >= 0.5 * parea) ? 1 : 0;
+	return writer;
 }
-*/
-import "C"
 
-import (
-	"image"
-	"io"
-	"os"
-	"path/filepath"
-	"sync"
-	"unsafe"
-)
+int write_band(fz_context *ctx, fz_band_writer *writer, fz_pixmap *pix) {
+	fz_try(ctx) {
+		fz_write_band(ctx, writer, fz_pixmap_stride(ctx, pix), fz_pixmap_height(ctx, pix), fz_pixmap_samples(ctx, pix));
+	}
+	fz_catch(ctx) {
+		return 0;
+	}
 
-// Document represents fitz document.
-type Document struct {
-	ctx    *C.struct_fz_context
-	data   []byte // binds data to the Document lifecycle avoiding premature GC
-	doc    *C.struct_fz_document
-	mtx    sync.Mutex
-	stream *C.fz_stream
+	return 1;
 }
 
-// New returns new fitz document.
-func New(filename string) (f *Document, err error) {
-	f = &Document{}
-
-	filename, err = filepath.Abs(filename)
-	if err != nil {
-		return
+int close_band_writer(fz_context *ctx, fz_band_writer *writer, fz_output *out) {
+	fz_try(ctx) {
+		fz_close_band_writer(ctx, writer);
+		fz_close_output(ctx, out);
 	}
-
-	if _, e := os.Stat(filename); e != nil {
-		err = ErrNoSuchFile
-		return
+	fz_catch(ctx) {
+		return 0;
 	}
 
-	f.ctx = (*C.struct_fz_context)(unsafe.Pointer(C.fz_new_context_imp(nil, nil, C.store(MaxStore), C.fz_version)))
-	if f.ctx == nil {
-		err = ErrCreateContext
-		return
+	return 1;
+}
+
+fz_buffer *new_buffer_from_pixmap(fz_context *ctx, fz_pixmap *pix, int format, int quality) {
+	fz_buffer *buf;
+
+	fz_try(ctx) {
+		switch (format) {
+		case 1:
+			buf = fz_new_buffer_from_pixmap_as_pam(ctx, pix, fz_default_color_params);
+			break;
+		case 2:
+			buf = fz_new_buffer_from_pixmap_as_jpeg(ctx, pix, fz_default_color_params, quality, 0);
+			break;
+		case 3:
+			buf = fz_new_buffer_from_pixmap_as_pnm(ctx, pix, fz_default_color_params);
+			break;
+		case 4:
+			buf = fz_new_buffer_from_pixmap_as_psd(ctx, pix, fz_default_color_params);
+			break;
+		case 5:
+			buf = fz_new_buffer_from_pixmap_as_jpx(ctx, pix, fz_default_color_params, quality);
+			break;
+		case 6:
+			buf = fz_new_buffer_from_pixmap_as_pkm(ctx, pix, fz_default_color_params);
+			break;
+		default:
+			buf = fz_new_buffer_from_pixmap_as_png(ctx, pix, fz_default_color_params);
+		}
+	}
+	fz_catch(ctx) {
+		return NULL;
 	}
 
-	C.silence_warnings(f.ctx)
+	return buf;
+}
 
-	C.fz_register_document_handlers(f.ctx)
+fz_display_list *new_display_list(fz_context *ctx, fz_page *page) {
+	fz_display_list *list = NULL;
+	fz_device *dev = NULL;
 
-	cfilename := C.CString(filename)
-	defer C.free(unsafe.Pointer(cfilename))
+	fz_var(list);
+	fz_var(dev);
 
-	f.doc = C.open_document(f.ctx, cfilename)
-	if f.doc == nil {
-		err = ErrOpenDocument
-		return
+	fz_try(ctx) {
+		list = fz_new_display_list(ctx, fz_bound_page(ctx, page));
+		dev = fz_new_list_device(ctx, list);
+		fz_run_page_contents(ctx, page, dev, fz_identity, NULL);
+		fz_close_device(ctx, dev);
 	}
-
-	ret := C.fz_needs_password(f.ctx, f.doc)
-	v := int(ret) != 0
-	if v {
-		err = ErrNeedsPassword
+	fz_always(ctx) {
+		fz_drop_device(ctx, dev);
+	}
+	fz_catch(ctx) {
+		fz_drop_display_list(ctx, list);
+		return NULL;
 	}
 
-	return
+	return list;
 }
 
-// NewFromMemory returns new fitz document from byte slice.
-func NewFromMemory(b []byte) (f *Document, err error) {
-	if len(b) == 0 {
-		return nil, ErrEmptyBytes
+int match_stext_page(fz_context *ctx, fz_stext_page *text, const char *needle, int *marks, fz_quad *quads, int max, int options) {
+	int n;
+
+	fz_try(ctx) {
+		n = fz_match_stext_page(ctx, text, needle, marks, quads, max, (fz_search_options)options);
+	}
+	fz_catch(ctx) {
+		return -1;
 	}
-	f = &Document{}
 
-	f.ctx = (*C.struct_fz_context)(unsafe.Pointer(C.fz_new_context_imp(nil, nil, C.store(MaxStore), C.fz_version)))
-	if f.ctx == nil {
-		err = ErrCreateContext
-		return
+	return n;
+}
+
+int parse_stext_options(fz_context *ctx, fz_stext_options *opts, const char *string) {
+	fz_try(ctx) {
+		fz_parse_stext_options(ctx, opts, string);
+	}
+	fz_catch(ctx) {
+		return 0;
 	}
 
-	C.silence_warnings(f.ctx)
+	return 1;
+}
 
-	C.fz_register_document_handlers(f.ctx)
+int resolve_link_dest(fz_context *ctx, fz_document *doc, const char *uri, fz_link_dest *dest) {
+	int page = -1;
 
-	f.stream = C.fz_open_memory(f.ctx, (*C.uchar)(&b[0]), C.size_t(len(b)))
-	if f.stream == nil {
-		err = ErrOpenMemory
-		return
+	fz_try(ctx) {
+		*dest = fz_resolve_link_dest(ctx, doc, uri);
+		if (dest->loc.page >= 0)
+			page = fz_page_number_from_location(ctx, doc, dest->loc);
 	}
-
-	magic := contentType(b)
-	if magic == "" {
-		err = ErrOpenMemory
-		return
+	fz_catch(ctx) {
+		return -1;
 	}
 
-	f.data = b
+	return page;
+}
 
-	cmagic := C.CString(magic)
-	defer C.free(unsafe.Pointer(cmagic))
+char *copy_rectangle(fz_context *ctx, fz_stext_page *text, fz_rect area) {
+	char *str;
 
-	f.doc = C.open_document_with_stream(f.ctx, cmagic, f.stream)
-	if f.doc == nil {
-		err = ErrOpenDocument
+	fz_try(ctx) {
+		str = fz_copy_rectangle(ctx, text, area, 0);
 	}
-
-	ret := C.fz_needs_password(f.ctx, f.doc)
-	v := int(ret) != 0
-	if v {
-		err = ErrNeedsPassword
+	fz_catch(ctx) {
+		return NULL;
 	}
 
-	return
+	return str;
 }
 
-// NewFromReader returns new fitz document from io.Reader.
-func NewFromReader(r io.Reader) (f *Document, err error) {
-	b, e := io.ReadAll(r)
-	if e != nil {
-		err = e
-		return
+char *copy_selection(fz_context *ctx, fz_stext_page *text, fz_point *a, fz_point *b, fz_quad *quads, int max, int *n) {
+	char *str;
+
+	fz_try(ctx) {
+		fz_snap_selection(ctx, text, a, b, FZ_SELECT_CHARS);
+		*n = fz_highlight_selection(ctx, text, *a, *b, quads, max);
+		str = fz_copy_selection(ctx, text, *a, *b, 0);
+	}
+	fz_catch(ctx) {
+		return NULL;
 	}
 
-	f, err = NewFromMemory(b)
+	return str;
+}
 
-	return
+fz_stext_line *stext_block_first_line(fz_stext_block *block) {
+	return block->u.t.first_line;
 }
 
-// NumPage returns total number of pages in document.
-func (f *Document) NumPage() int {
-	return int(C.fz_count_pages(f.ctx, f.doc))
+fz_image *stext_block_image(fz_stext_block *block) {
+	return block->u.i.image;
 }
 
-// Image returns the page at its native resolution (300 DPI if it has no images), cropped to a single full-page image.
-func (f *Document) Image(pageNumber int) (*image.RGBA, error) {
-	dpi, x0, y0, x1, y1, crop := f.pageInfo(pageNumber)
-	if dpi <= 0 {
-		dpi = 300.0
-	}
+fz_stext_struct *stext_block_down(fz_stext_block *block) {
+	return block->u.s.down;
+}
 
-	img, err := f.ImageDPI(pageNumber, dpi)
-	if err != nil {
-		return nil, err
-	}
+fz_page *load_chapter_page(fz_context *ctx, fz_document *doc, int chapter, int number) {
+	fz_page *page;
 
-	if crop {
-		img = cropImage(img, x0, y0, x1, y1, dpi)
+	fz_try(ctx) {
+		page = fz_load_chapter_page(ctx, doc, chapter, number);
+	}
+	fz_catch(ctx) {
+		return NULL;
 	}
 
-	return img, nil
+	return page;
 }
 
-// pageInfo returns the native resolution and, for a single full-page image, its bbox (points) with crop=true.
-func (f *Document) pageInfo(pageNumber int) (dpi, x0, y0, x1, y1 float64, crop bool) {
-	f.mtx.Lock()
-	defer f.mtx.Unlock()
+// count_chapter_pages returns the number of pages in chapter, or -1.
+int count_chapter_pages(fz_context *ctx, fz_document *doc, int chapter) {
+	int n = -1;
 
-	if pageNumber >= f.NumPage() {
-		return
+	fz_try(ctx) {
+		n = fz_count_chapter_pages(ctx, doc, chapter);
 	}
-
-	page := C.load_page(f.ctx, f.doc, C.int(pageNumber))
-	if page == nil {
-		return
+	fz_catch(ctx) {
+		return -1;
 	}
 
-	defer C.fz_drop_page(f.ctx, page)
+	return n;
+}
 
-	var cdpi C.double
-	var box C.fz_rect
-	c := C.page_info(f.ctx, page, &cdpi, &box)
+// step_location moves *loc to the next page, or to the previous page if prev is set, staying put at either end.
+int step_location(fz_context *ctx, fz_document *doc, fz_location *loc, int prev) {
+	fz_try(ctx) {
+		*loc = prev ? fz_previous_page(ctx, doc, *loc) : fz_next_page(ctx, doc, *loc);
+	}
+	fz_catch(ctx) {
+		return 0;
+	}
 
-	return float64(cdpi), float64(box.x0), float64(box.y0), float64(box.x1), float64(box.y1), c != 0
+	return 1;
 }
 
-// ImageDPI returns image for given page number and DPI.
-func (f *Document) ImageDPI(pageNumber int, dpi float64) (*image.RGBA, error) {
-	f.mtx.Lock()
-	defer f.mtx.Unlock()
-
-	if pageNumber >= f.NumPage() {
-		return nil, ErrPageMissing
+int location_from_page_number(fz_context *ctx, fz_document *doc, int number, fz_location *loc) {
+	fz_try(ctx) {
+		*loc = fz_location_from_page_number(ctx, doc, number);
 	}
-
-	page := C.load_page(f.ctx, f.doc, C.int(pageNumber))
-	if page == nil {
-		return nil, ErrLoadPage
+	fz_catch(ctx) {
+		return 0;
 	}
 
-	defer C.fz_drop_page(f.ctx, page)
+	return 1;
+}
 
-	var bounds C.fz_rect
-	bounds = C.fz_bound_page(f.ctx, page)
+// page_number_from_location returns the absolute page number of loc, or -1.
+int page_number_from_location(fz_context *ctx, fz_document *doc, fz_location loc) {
+	int number = -1;
 
-	var ctm C.fz_matrix
+	fz_try(ctx) {
+		number = fz_page_number_from_location(ctx, doc, loc);
+	}
+	fz_catch(ctx) {
+		return -1;
+	}
+
+	return number;
+}
+
+int bound_page_box(fz_context *ctx, fz_page *page, int box, fz_rect *rect) {
+	fz_try(ctx) {
+		*rect = fz_bound_page_box(ctx, page, (fz_box_type)box);
+	}
+	fz_catch(ctx) {
+		return 0;
+	}
+
+	return 1;
+}
+
+int label_page(fz_context *ctx, fz_page *page, char *buf, int size) {
+	fz_try(ctx) {
+		fz_page_label(ctx, page, buf, size);
+	}
+	fz_catch(ctx) {
+		return 0;
+	}
+
+	return 1;
+}
+
+int page_label(fz_context *ctx, fz_document *doc, fz_location loc, char *buf, int size) {
+	fz_page *page = NULL;
+
+	fz_var(page);
+
+	fz_try(ctx) {
+		page = fz_load_chapter_page(ctx, doc, loc.chapter, loc.page);
+		fz_page_label(ctx, page, buf, size);
+	}
+	fz_always(ctx) {
+		fz_drop_page(ctx, page);
+	}
+	fz_catch(ctx) {
+		return 0;
+	}
+
+	return 1;
+}
+
+int layout_document(fz_context *ctx, fz_document *doc, float w, float h, float em) {
+	fz_try(ctx) {
+		fz_layout_document(ctx, doc, w, h, em);
+	}
+	fz_catch(ctx) {
+		return 0;
+	}
+
+	return 1;
+}
+
+int make_bookmark(fz_context *ctx, fz_document *doc, int number, fz_bookmark *mark) {
+	fz_try(ctx) {
+		*mark = fz_make_bookmark(ctx, doc, fz_location_from_page_number(ctx, doc, number));
+	}
+	fz_catch(ctx) {
+		return 0;
+	}
+
+	return 1;
+}
+
+// lookup_bookmark returns the absolute page number of a bookmark, or -1.
+int lookup_bookmark(fz_context *ctx, fz_document *doc, fz_bookmark mark) {
+	int number = -1;
+
+	fz_try(ctx) {
+		fz_location loc = fz_lookup_bookmark(ctx, doc, mark);
+		if (loc.page >= 0)
+			number = fz_page_number_from_location(ctx, doc, loc);
+	}
+	fz_catch(ctx) {
+		return -1;
+	}
+
+	return number;
+}
+
+// image_original_type returns the FZ_IMAGE_* type of the compressed bytes of an image if they form a standalone image file, or 0.
+int image_original_type(fz_context *ctx, fz_image *img) {
+	fz_compressed_buffer *cbuf = fz_compressed_image_buffer(ctx, img);
+	if (cbuf == NULL || cbuf->buffer == NULL || cbuf->params.type < FZ_IMAGE_BMP)
+		return 0;
+	if (cbuf->params.type == FZ_IMAGE_JBIG2 && cbuf->params.u.jbig2.embedded)
+		return 0;
+
+	return cbuf->params.type;
+}
+
+fz_buffer *new_buffer_from_image_as_png(fz_context *ctx, fz_image *img) {
+	fz_buffer *buf;
+
+	fz_try(ctx) {
+		buf = fz_new_buffer_from_image_as_png(ctx, img, fz_default_color_params);
+	}
+	fz_catch(ctx) {
+		return NULL;
+	}
+
+	return buf;
+}
+
+extern void goWriteOutput(fz_context *ctx, uintptr_t state, void *data, size_t n);
+
+static void write_output(fz_context *ctx, void *state, const void *data, size_t n) {
+	goWriteOutput(ctx, (uintptr_t)state, (void *)data, n);
+}
+
+fz_output *new_output_with_writer(fz_context *ctx, uintptr_t handle) {
+	fz_output *out;
+
+	fz_try(ctx) {
+		out = fz_new_output(ctx, 8192, (void *)handle, write_output, NULL, NULL);
+	}
+	fz_catch(ctx) {
+		return NULL;
+	}
+
+	return out;
+}
+
+// print_stext_page writes text to out in the given TextFormat.
+int print_stext_page(fz_context *ctx, fz_output *out, fz_stext_page *text, int format, int id) {
+	fz_try(ctx) {
+		switch (format) {
+		case 1:
+			fz_print_stext_header_as_html(ctx, out);
+			fz_print_stext_page_as_html(ctx, out, text, id);
+			fz_print_stext_trailer_as_html(ctx, out);
+			break;
+		case 2:
+			fz_print_stext_header_as_xhtml(ctx, out);
+			fz_print_stext_page_as_xhtml(ctx, out, text, id);
+			fz_print_stext_trailer_as_xhtml(ctx, out);
+			break;
+		case 3:
+			fz_print_stext_page_as_xml(ctx, out, text, id);
+			break;
+		case 4:
+			fz_print_stext_page_as_json(ctx, out, text, 1);
+			break;
+		default:
+			fz_print_stext_page_as_text(ctx, out, text);
+		}
+		fz_close_output(ctx, out);
+	}
+	fz_catch(ctx) {
+		return 0;
+	}
+
+	return 1;
+}
+
+static void silent_warning(void *user, const char *message) {}
+
+void silence_warnings(fz_context *ctx) {
+	fz_set_warning_callback(ctx, silent_warning, NULL);
+}
+
+// page_info sets *dpi to the page's native image resolution; for a single full-page image with no text it also sets *box and returns 1.
+int page_info(fz_context *ctx, fz_page *page, double *dpi, fz_rect *box) {
+	fz_rect b = fz_bound_page(ctx, page);
+	double parea = (b.x1 - b.x0) * (b.y1 - b.y0);
+	fz_stext_page *text = fz_new_stext_page(ctx, b);
+	fz_stext_options opts = {0};
+	opts.flags = FZ_STEXT_PRESERVE_IMAGES;
+
+	double nd = 0, barea = 0;
+	int has_text = 0;
+	fz_rect bb = fz_empty_rect;
+
+	fz_try(ctx) {
+		fz_device *dev = fz_new_stext_device(ctx, text, &opts);
+		fz_run_page(ctx, page, dev, fz_identity, NULL);
+		fz_close_device(ctx, dev);
+		fz_drop_device(ctx, dev);
+
+		for (fz_stext_block *blk = text->first_block; blk; blk = blk->next) {
+			if (blk->type == FZ_STEXT_BLOCK_TEXT) {
+				has_text = 1;
+				continue;
+			}
+			if (blk->type != FZ_STEXT_BLOCK_IMAGE)
+				continue;
+			fz_image *img = blk->u.i.image;
+			double bw = blk->bbox.x1 - blk->bbox.x0;
+			double bh = blk->bbox.y1 - blk->bbox.y0;
+			if (bw > 0 && img->w * 72.0 / bw > nd)
+				nd = img->w * 72.0 / bw;
+			if (bh > 0 && img->h * 72.0 / bh > nd)
+				nd = img->h * 72.0 / bh;
+			if (bw * bh > barea) {
+				barea = bw * bh;
+				bb = blk->bbox;
+			}
+		}
+	}
+	fz_always(ctx)
+		fz_drop_stext_page(ctx, text);
+	fz_catch(ctx) {
+		nd = 0;
+		has_text = 1;
+	}
+
+	*dpi = nd;
+	*box = bb;
+
+	return (!has_text && parea > 0 && barea >= 0.5 * parea) ? 1 : 0;
+}
+
+fz_document_writer *new_document_writer(fz_context *ctx, fz_output *out, const char *format, const char *options) {
+	fz_document_writer *wri = NULL;
+
+	fz_var(wri);
+
+	fz_try(ctx) {
+		wri = fz_new_document_writer_with_output(ctx, out, format, options);
+	}
+	fz_catch(ctx) {
+		return NULL;
+	}
+
+	return wri;
+}
+
+fz_device *begin_page(fz_context *ctx, fz_document_writer *wri, fz_rect mediabox) {
+	fz_device *dev = NULL;
+
+	fz_var(dev);
+
+	fz_try(ctx) {
+		dev = fz_begin_page(ctx, wri, mediabox);
+	}
+	fz_catch(ctx) {
+		return NULL;
+	}
+
+	return dev;
+}
+
+int end_page(fz_context *ctx, fz_document_writer *wri) {
+	fz_try(ctx) {
+		fz_end_page(ctx, wri);
+	}
+	fz_catch(ctx) {
+		return 0;
+	}
+
+	return 1;
+}
+
+int run_page(fz_context *ctx, fz_page *page, fz_device *dev, fz_matrix transform, fz_cookie *cookie) {
+	fz_try(ctx) {
+		fz_run_page(ctx, page, dev, transform, cookie);
+	}
+	fz_catch(ctx) {
+		return 0;
+	}
+
+	return 1;
+}
+
+fz_buffer *new_buffer_from_page_as_pdf(fz_context *ctx, fz_page *page) {
+	fz_buffer *buf = NULL;
+	fz_document_writer *wri = NULL;
+	fz_output *out;
+	fz_device *dev;
+
+	fz_var(buf);
+	fz_var(wri);
+
+	fz_try(ctx) {
+		buf = fz_new_buffer(ctx, 8192);
+		out = fz_new_output_with_buffer(ctx, buf);
+		wri = fz_new_document_writer_with_output(ctx, out, "pdf", "");
+		dev = fz_begin_page(ctx, wri, fz_bound_page(ctx, page));
+		fz_run_page(ctx, page, dev, fz_identity, NULL);
+		fz_end_page(ctx, wri);
+		fz_close_document_writer(ctx, wri);
+	}
+	fz_always(ctx)
+		fz_drop_document_writer(ctx, wri);
+	fz_catch(ctx) {
+		fz_drop_buffer(ctx, buf);
+		return NULL;
+	}
+
+	return buf;
+}
+
+int run_pdf_page(fz_context *ctx, const unsigned char *data, size_t size, fz_rect bounds, fz_device *dev, fz_matrix transform) {
+	fz_buffer *buf = NULL;
+	fz_document *doc = NULL;
+	fz_page *page = NULL;
+	int ok = 1;
+
+	fz_var(buf);
+	fz_var(doc);
+	fz_var(page);
+
+	fz_try(ctx) {
+		buf = fz_new_buffer_from_copied_data(ctx, data, size);
+		doc = fz_open_document_with_buffer(ctx, "application/pdf", buf);
+		page = fz_load_page(ctx, doc, 0);
+		fz_rect r = fz_bound_page(ctx, page);
+		fz_run_page(ctx, page, dev, fz_concat(fz_translate(bounds.x0 - r.x0, bounds.y0 - r.y0), transform), NULL);
+	}
+	fz_always(ctx) {
+		fz_drop_page(ctx, page);
+		fz_drop_document(ctx, doc);
+		fz_drop_buffer(ctx, buf);
+	}
+	fz_catch(ctx) {
+		ok = 0;
+	}
+
+	return ok;
+}
+
+int close_document_writer(fz_context *ctx, fz_document_writer *wri) {
+	fz_try(ctx) {
+		fz_close_document_writer(ctx, wri);
+	}
+	fz_catch(ctx) {
+		return 0;
+	}
+
+	return 1;
+}
+*/
+import "C"
+
+import (
+	"context"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/cgo"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// Document represents fitz document.
+type Document struct {
+	ctx    *C.struct_fz_context
+	data   []byte // binds data to the Document lifecycle avoiding premature GC
+	doc    *C.struct_fz_document
+	mtx    sync.Mutex
+	stream *C.fz_stream
+	lists  displayListCache
+}
+
+// New returns new fitz document.
+func New(filename string) (f *Document, err error) {
+	f = &Document{}
+	f.lists.capacity = MaxDisplayLists
+
+	filename, err = filepath.Abs(filename)
+	if err != nil {
+		return
+	}
+
+	if _, e := os.Stat(filename); e != nil {
+		err = ErrNoSuchFile
+		return
+	}
+
+	f.ctx = (*C.struct_fz_context)(unsafe.Pointer(C.fz_new_context_imp(nil, nil, C.store(MaxStore), C.fz_version)))
+	if f.ctx == nil {
+		err = ErrCreateContext
+		return
+	}
+
+	C.silence_warnings(f.ctx)
+
+	C.fz_register_document_handlers(f.ctx)
+
+	cfilename := C.CString(filename)
+	defer C.free(unsafe.Pointer(cfilename))
+
+	f.doc = C.open_document(f.ctx, cfilename)
+	if f.doc == nil {
+		err = ErrOpenDocument
+		return
+	}
+
+	ret := C.fz_needs_password(f.ctx, f.doc)
+	v := int(ret) != 0
+	if v {
+		err = ErrNeedsPassword
+	}
+
+	return
+}
+
+// NewFromMemory returns new fitz document from byte slice.
+func NewFromMemory(b []byte) (f *Document, err error) {
+	if len(b) == 0 {
+		return nil, ErrEmptyBytes
+	}
+	f = &Document{}
+	f.lists.capacity = MaxDisplayLists
+
+	f.ctx = (*C.struct_fz_context)(unsafe.Pointer(C.fz_new_context_imp(nil, nil, C.store(MaxStore), C.fz_version)))
+	if f.ctx == nil {
+		err = ErrCreateContext
+		return
+	}
+
+	C.silence_warnings(f.ctx)
+
+	C.fz_register_document_handlers(f.ctx)
+
+	f.stream = C.fz_open_memory(f.ctx, (*C.uchar)(&b[0]), C.size_t(len(b)))
+	if f.stream == nil {
+		err = ErrOpenMemory
+		return
+	}
+
+	magic := contentType(b)
+	if magic == "" {
+		err = ErrOpenMemory
+		return
+	}
+
+	f.data = b
+
+	cmagic := C.CString(magic)
+	defer C.free(unsafe.Pointer(cmagic))
+
+	f.doc = C.open_document_with_stream(f.ctx, cmagic, f.stream)
+	if f.doc == nil {
+		err = ErrOpenDocument
+		return
+	}
+
+	ret := C.fz_needs_password(f.ctx, f.doc)
+	v := int(ret) != 0
+	if v {
+		err = ErrNeedsPassword
+	}
+
+	return
+}
+
+// NewFromReader returns new fitz document from io.Reader.
+func NewFromReader(r io.Reader) (f *Document, err error) {
+	b, e := io.ReadAll(r)
+	if e != nil {
+		err = e
+		return
+	}
+
+	f, err = NewFromMemory(b)
+
+	return
+}
+
+// NewWithPassword returns new fitz document, authenticated with the given password.
+func NewWithPassword(filename, password string) (f *Document, err error) {
+	f, err = New(filename)
+	if err != ErrNeedsPassword {
+		return
+	}
+
+	if _, err = f.Authenticate(password); err != nil {
+		f.Close()
+		f = nil
+	}
+
+	return
+}
+
+// NewFromMemoryWithPassword returns new fitz document from byte slice, authenticated with the given password.
+func NewFromMemoryWithPassword(b []byte, password string) (f *Document, err error) {
+	f, err = NewFromMemory(b)
+	if err != ErrNeedsPassword {
+		return
+	}
+
+	if _, err = f.Authenticate(password); err != nil {
+		f.Close()
+		f = nil
+	}
+
+	return
+}
+
+// Authenticate unlocks an encrypted document with the user or owner password.
+func (f *Document) Authenticate(password string) (AuthResult, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	cpassword := C.CString(password)
+	defer C.free(unsafe.Pointer(cpassword))
+
+	ret := C.authenticate_password(f.ctx, f.doc, cpassword)
+	if ret == 0 {
+		return 0, ErrWrongPassword
+	}
+
+	return AuthResult(ret), nil
+}
+
+// NumPage returns total number of pages in document.
+func (f *Document) NumPage() int {
+	return int(C.fz_count_pages(f.ctx, f.doc))
+}
+
+// Image returns the page at its native resolution (300 DPI if it has no images), cropped to a single full-page image.
+func (f *Document) Image(pageNumber int) (*image.RGBA, error) {
+	dpi, box, crop := f.pageInfo(pageNumber)
+	if dpi <= 0 {
+		dpi = 300.0
+	}
+
+	img, err := f.ImageDPI(pageNumber, dpi)
+	if err != nil {
+		return nil, err
+	}
+
+	if crop {
+		img = cropImage(img, box, dpi)
+	}
+
+	return img, nil
+}
+
+// pageInfo returns the native resolution and, for a single full-page image, its bbox (points) with crop=true.
+func (f *Document) pageInfo(pageNumber int) (dpi float64, box Rect, crop bool) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if pageNumber >= f.NumPage() {
+		return
+	}
+
+	page := C.load_page(f.ctx, f.doc, C.int(pageNumber))
+	if page == nil {
+		return
+	}
+
+	defer C.fz_drop_page(f.ctx, page)
+
+	var cdpi C.double
+	var cbox C.fz_rect
+	c := C.page_info(f.ctx, page, &cdpi, &cbox)
+
+	return float64(cdpi), goRect(cbox), c != 0
+}
+
+// ImageDPI returns image for given page number and DPI.
+func (f *Document) ImageDPI(pageNumber int, dpi float64) (*image.RGBA, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	defer page.Close()
+
+	return page.Render(dpi)
+}
+
+// Render returns image for given page number, rendered with the given options.
+// It is an *image.RGBA, *image.Gray, *image.CMYK or *BGRA depending on the colorspace.
+func (f *Document) Render(pageNumber int, opts RenderOptions) (image.Image, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	defer page.Close()
+
+	return page.RenderWithOptions(opts)
+}
+
+// RenderInto renders given page number at the given DPI directly into dst, avoiding allocations.
+// The dst image must have the size of the rendered page, as returned by ImageDPI.
+func (f *Document) RenderInto(pageNumber int, dst *image.RGBA, dpi float64) error {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return err
+	}
+
+	defer page.Close()
+
+	return page.RenderInto(dst, dpi)
+}
+
+// Links returns slice of links for given page number.
+func (f *Document) Links(pageNumber int) ([]Link, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	defer page.Close()
+
+	return page.Links()
+}
+
+// ImageDPIContext returns image for given page number and DPI, aborting when ctx is done and reporting to progress if not nil.
+// It returns the image along with ErrPartialRender if errors were encountered while rendering.
+func (f *Document) ImageDPIContext(ctx context.Context, pageNumber int, dpi float64, progress ProgressFunc) (*image.RGBA, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	defer page.Close()
+
+	return page.RenderContext(ctx, dpi, progress)
+}
+
+// Text returns text for given page number.
+func (f *Document) Text(pageNumber int) (string, error) {
+	return f.TextWithOptions(pageNumber, TextOptions{})
+}
+
+// TextContext returns text for given page number, aborting when ctx is done and reporting to progress if not nil.
+// It returns the text along with ErrPartialRender if errors were encountered while extracting it.
+func (f *Document) TextContext(ctx context.Context, pageNumber int, progress ProgressFunc) (string, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return "", err
+	}
+
+	defer page.Close()
+
+	return page.TextContext(ctx, progress)
+}
+
+// TextWithOptions returns text for given page number, extracted with the given options.
+func (f *Document) TextWithOptions(pageNumber int, opts TextOptions) (string, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return "", err
+	}
+
+	defer page.Close()
+
+	return page.TextWithOptions(opts)
+}
+
+// stextOptions returns the fz_stext_options for opts.
+func (f *Document) stextOptions(opts TextOptions) (C.fz_stext_options, error) {
+	var sopts C.fz_stext_options
+
+	if err := opts.check(); err != nil {
+		return sopts, err
+	}
+
+	if opts.Options != "" {
+		coptions := C.CString(opts.Options)
+		defer C.free(unsafe.Pointer(coptions))
+
+		if C.parse_stext_options(f.ctx, &sopts, coptions) == 0 {
+			return sopts, ErrTextOptions
+		}
+	}
+
+	sopts.flags |= C.int(opts.flags())
+
+	return sopts, nil
+}
+
+// newSTextPage loads given page number and extracts its structured text, see (*Page).newSTextPage.
+// The caller holds the document mutex and drops the text.
+func (f *Document) newSTextPage(pageNumber int, opts TextOptions, flags int) (*C.fz_stext_page, error) {
+	if pageNumber >= f.NumPage() {
+		return nil, ErrPageMissing
+	}
+
+	page := C.load_page(f.ctx, f.doc, C.int(pageNumber))
+	if page == nil {
+		return nil, ErrLoadPage
+	}
+
+	p := &Page{doc: f, page: page, number: pageNumber}
+	defer p.drop()
+
+	return p.newSTextPage(opts, flags, nil)
+}
+
+// StructuredText returns the blocks, lines, spans and characters for given page number.
+func (f *Document) StructuredText(pageNumber int, opts TextOptions) (*TextPage, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	text, err := f.newSTextPage(pageNumber, opts, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer C.fz_drop_stext_page(f.ctx, text)
+
+	return f.textPage(text), nil
+}
+
+// textPage converts the fz_stext_page to a TextPage.
+func (f *Document) textPage(text *C.fz_stext_page) *TextPage {
+	fonts := make(map[*C.fz_font]string)
+
+	tp := &TextPage{Mediabox: goRect(text.mediabox)}
+
+	var walk func(blk *C.fz_stext_block)
+
+	walk = func(blk *C.fz_stext_block) {
+		for ; blk != nil; blk = blk.next {
+			block := TextBlock{Type: TextBlockType(blk._type), BBox: goRect(blk.bbox)}
+
+			switch blk._type {
+			case C.FZ_STEXT_BLOCK_STRUCT:
+				if down := C.stext_block_down(blk); down != nil {
+					walk(down.first_block)
+				}
+				continue
+			case C.FZ_STEXT_BLOCK_TEXT:
+				for ln := C.stext_block_first_line(blk); ln != nil; ln = ln.next {
+					line := TextLine{WMode: int(ln.wmode), Dir: goPoint(ln.dir), BBox: goRect(ln.bbox)}
+					for ch := ln.first_char; ch != nil; ch = ch.next {
+						font, ok := fonts[ch.font]
+						if !ok {
+							font = C.GoString(C.fz_font_name(f.ctx, ch.font))
+							fonts[ch.font] = font
+						}
+
+						c := TextChar{Rune: rune(ch.c), Origin: goPoint(ch.origin), Quad: goQuad(ch.quad), Bidi: int(ch.bidi)}
+						line.addChar(c, font, float64(ch.size), uint32(ch.argb), CharFlags(ch.flags))
+					}
+					block.Lines = append(block.Lines, line)
+				}
+			case C.FZ_STEXT_BLOCK_IMAGE:
+				img := C.stext_block_image(blk)
+				block.ImageWidth, block.ImageHeight = int(img.w), int(img.h)
+			}
+
+			tp.Blocks = append(tp.Blocks, block)
+		}
+	}
+
+	walk(text.first_block)
+
+	return tp
+}
+
+// TextInRect returns the text for given page number inside the rectangle r, in page points.
+func (f *Document) TextInRect(pageNumber int, r Rect) (string, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if pageNumber >= f.NumPage() {
+		return "", ErrPageMissing
+	}
+
+	text, err := f.newSTextPage(pageNumber, TextOptions{}, 0)
+	if err != nil {
+		return "", err
+	}
+
+	defer C.fz_drop_stext_page(f.ctx, text)
+
+	str := C.copy_rectangle(f.ctx, text, fzRectOf(r))
+	if str == nil {
+		return "", ErrCopyText
+	}
+
+	defer C.fz_free(f.ctx, unsafe.Pointer(str))
+
+	return C.GoString(str), nil
+}
+
+// SelectText returns the text for given page number selected from point a to point b, in page points,
+// along with the quads to highlight it.
+func (f *Document) SelectText(pageNumber int, a, b Point) (string, []Quad, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if pageNumber >= f.NumPage() {
+		return "", nil, ErrPageMissing
+	}
+
+	text, err := f.newSTextPage(pageNumber, TextOptions{}, 0)
+	if err != nil {
+		return "", nil, err
+	}
+
+	defer C.fz_drop_stext_page(f.ctx, text)
+
+	ca, cb := fzPointOf(a), fzPointOf(b)
+	quads := make([]C.fz_quad, selectMaxQuads)
+
+	var n C.int
+	str := C.copy_selection(f.ctx, text, &ca, &cb, &quads[0], C.int(len(quads)), &n)
+	if str == nil {
+		return "", nil, ErrCopyText
+	}
+
+	defer C.fz_free(f.ctx, unsafe.Pointer(str))
+
+	hits := make([]Quad, n)
+	for i := range hits {
+		hits[i] = goQuad(quads[i])
+	}
+
+	return C.GoString(str), hits, nil
+}
+
+// Paragraphs returns the paragraphs for given page number in reading order, segmenting the page into regions such as columns.
+func (f *Document) Paragraphs(pageNumber int) ([]Paragraph, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if pageNumber >= f.NumPage() {
+		return nil, ErrPageMissing
+	}
+
+	text, err := f.newSTextPage(pageNumber, TextOptions{}, C.FZ_STEXT_DEHYPHENATE|C.FZ_STEXT_SEGMENT|C.FZ_STEXT_PARAGRAPH_BREAK)
+	if err != nil {
+		return nil, err
+	}
+
+	defer C.fz_drop_stext_page(f.ctx, text)
+
+	var data []Paragraph
+	var regions int
+
+	var walk func(blk *C.fz_stext_block, region int, regionBBox Rect)
+
+	walk = func(blk *C.fz_stext_block, region int, regionBBox Rect) {
+		for ; blk != nil; blk = blk.next {
+			switch blk._type {
+			case C.FZ_STEXT_BLOCK_TEXT:
+				var b strings.Builder
+				for ln := C.stext_block_first_line(blk); ln != nil; ln = ln.next {
+					for ch := ln.first_char; ch != nil; ch = ch.next {
+						b.WriteRune(rune(ch.c))
+					}
+					if ln.next != nil && ln.flags&fzStextLineFlagsJoined == 0 {
+						b.WriteByte(' ')
+					}
+				}
+
+				data = append(data, Paragraph{Text: b.String(), BBox: goRect(blk.bbox), Region: region, RegionBBox: regionBBox})
+			case C.FZ_STEXT_BLOCK_STRUCT:
+				if down := C.stext_block_down(blk); down != nil {
+					regions++
+					walk(down.first_block, regions, goRect(blk.bbox))
+				}
+			}
+		}
+	}
+
+	walk(text.first_block, 0, goRect(text.mediabox))
+
+	return data, nil
+}
+
+// Tables returns the tables found on given page number.
+func (f *Document) Tables(pageNumber int) ([]Table, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if pageNumber >= f.NumPage() {
+		return nil, ErrPageMissing
+	}
+
+	text, err := f.newSTextPage(pageNumber, TextOptions{}, C.FZ_STEXT_COLLECT_VECTORS|C.FZ_STEXT_TABLE_HUNT)
+	if err != nil {
+		return nil, err
+	}
+
+	defer C.fz_drop_stext_page(f.ctx, text)
+
+	return tables(text.first_block, nil), nil
+}
+
+// tables appends the tables found by the table hunt in the blocks starting at blk.
+func tables(blk *C.fz_stext_block, ts []Table) []Table {
+	for ; blk != nil; blk = blk.next {
+		if blk._type != C.FZ_STEXT_BLOCK_STRUCT {
+			continue
+		}
+
+		down := C.stext_block_down(blk)
+		if down == nil {
+			continue
+		}
+
+		if down.standard != C.FZ_STRUCTURE_TABLE {
+			ts = tables(down.first_block, ts)
+			continue
+		}
+
+		t := Table{BBox: goRect(blk.bbox)}
+		for tr := down.first_block; tr != nil; tr = tr.next {
+			row := C.stext_block_down(tr)
+			if tr._type != C.FZ_STEXT_BLOCK_STRUCT || row == nil || row.standard != C.FZ_STRUCTURE_TR {
+				continue
+			}
+
+			var cells []TableCell
+			for td := row.first_block; td != nil; td = td.next {
+				cell := C.stext_block_down(td)
+				if td._type != C.FZ_STEXT_BLOCK_STRUCT || cell == nil || (cell.standard != C.FZ_STRUCTURE_TD && cell.standard != C.FZ_STRUCTURE_TH) {
+					continue
+				}
+
+				cells = append(cells, TableCell{BBox: goRect(td.bbox), Text: strings.Join(blockLines(cell.first_block, nil), "\n")})
+			}
+			t.Rows = append(t.Rows, cells)
+		}
+
+		ts = append(ts, t)
+	}
+
+	return ts
+}
+
+// blockLines appends the text of each line in the blocks starting at blk, descending into structure blocks.
+func blockLines(blk *C.fz_stext_block, lines []string) []string {
+	for ; blk != nil; blk = blk.next {
+		switch blk._type {
+		case C.FZ_STEXT_BLOCK_TEXT:
+			for ln := C.stext_block_first_line(blk); ln != nil; ln = ln.next {
+				var b strings.Builder
+				for ch := ln.first_char; ch != nil; ch = ch.next {
+					b.WriteRune(rune(ch.c))
+				}
+				lines = append(lines, b.String())
+			}
+		case C.FZ_STEXT_BLOCK_STRUCT:
+			if down := C.stext_block_down(blk); down != nil {
+				lines = blockLines(down.first_block, lines)
+			}
+		}
+	}
+
+	return lines
+}
+
+// SearchWithOptions returns the hit quads of a search for needle on given page number, at most maxHits.
+func (f *Document) SearchWithOptions(pageNumber int, needle string, maxHits int, opts SearchOptions) ([]Quad, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if pageNumber >= f.NumPage() {
+		return nil, ErrPageMissing
+	}
+
+	if maxHits <= 0 || needle == "" {
+		return nil, nil
+	}
+
+	return f.search(pageNumber, needle, maxHits, opts, false)
+}
+
+// search returns the hit quads of a search for needle on given page number, at most maxHits, or all of them if all is set
+// by growing the buffer while it fills up. The caller holds the document mutex.
+func (f *Document) search(pageNumber int, needle string, maxHits int, opts SearchOptions, all bool) ([]Quad, error) {
+	if err := opts.check(needle); err != nil {
+		return nil, err
+	}
+
+	text, err := f.newSTextPage(pageNumber, TextOptions{}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer C.fz_drop_stext_page(f.ctx, text)
+
+	cneedle := C.CString(needle)
+	defer C.free(unsafe.Pointer(cneedle))
+
+	for {
+		marks := make([]C.int, maxHits)
+		quads := make([]C.fz_quad, maxHits)
+
+		n := C.match_stext_page(f.ctx, text, cneedle, &marks[0], &quads[0], C.int(maxHits), C.int(opts.flags()))
+		if n < 0 {
+			return nil, ErrSearch
+		}
+
+		if all && int(n) == maxHits {
+			maxHits *= 2
+			continue
+		}
+
+		hits := make([]Quad, n)
+		for i := range hits {
+			hits[i] = goQuad(quads[i])
+		}
+
+		return hits, nil
+	}
+}
+
+// HTML returns html for given page number.
+func (f *Document) HTML(pageNumber int, header bool) (string, error) {
+	return f.HTMLWithOptions(pageNumber, header, TextOptions{PreserveImages: true})
+}
+
+// HTMLWithOptions returns html for given page number, extracted with the given options.
+func (f *Document) HTMLWithOptions(pageNumber int, header bool, opts TextOptions) (string, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return "", err
+	}
+
+	defer page.Close()
+
+	return page.HTMLWithOptions(header, opts)
+}
+
+// TextAs writes the text for given page number to w in the given format.
+func (f *Document) TextAs(pageNumber int, format TextFormat, w io.Writer) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if pageNumber >= f.NumPage() {
+		return ErrPageMissing
+	}
+
+	text, err := f.newSTextPage(pageNumber, format.textOptions(), 0)
+	if err != nil {
+		return err
+	}
+
+	defer C.fz_drop_stext_page(f.ctx, text)
+
+	out, o := newOutput(f.ctx, w)
+	if out == nil {
+		return ErrCreateOutput
+	}
+
+	ret := C.print_stext_page(f.ctx, out, text, C.int(format), C.int(pageNumber))
+	if err := dropOutput(f.ctx, out, o); err != nil {
+		return err
+	}
+
+	if ret == 0 {
+		return ErrWriteOutput
+	}
+
+	return nil
+}
+
+// ConvertContext is like Convert, aborting between pages when ctx is done.
+// It calls progress, if not nil, after each page with the number of pages written, out of the pages to write.
+func ConvertContext(ctx context.Context, src *Document, w io.Writer, format string, options string, pages []int, progress ProgressFunc) error {
+	format, ok := documentFormat(format)
+	if !ok {
+		return ErrDocumentFormat
+	}
+
+	src.mtx.Lock()
+	defer src.mtx.Unlock()
+
+	pages, err := convertPages(pages, src.NumPage())
+	if err != nil {
+		return err
+	}
+
+	out, o := newOutput(src.ctx, w)
+	if out == nil {
+		return ErrCreateOutput
+	}
+
+	cformat := C.CString(format)
+	defer C.free(unsafe.Pointer(cformat))
+
+	coptions := C.CString(options)
+	defer C.free(unsafe.Pointer(coptions))
+
+	// The writer owns out from here on, and drops it even if it cannot be created.
+	wri := C.new_document_writer(src.ctx, out, cformat, coptions)
+	if wri == nil {
+		releaseOutput(o)
+		return ErrCreateWriter
+	}
+
+	for i, number := range pages {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+
+		if err = src.writePage(wri, number); err != nil {
+			break
+		}
+
+		if progress != nil {
+			progress(i+1, len(pages))
+		}
+	}
+
+	if err == nil && C.close_document_writer(src.ctx, wri) == 0 {
+		err = ErrWriteOutput
+	}
+
+	C.fz_drop_document_writer(src.ctx, wri)
+
+	if e := releaseOutput(o); e != nil {
+		return e
+	}
+
+	return err
+}
+
+// writePage writes given page number to wri, with the page bounds as mediabox.
+func (f *Document) writePage(wri *C.fz_document_writer, pageNumber int) error {
+	page := C.load_page(f.ctx, f.doc, C.int(pageNumber))
+	if page == nil {
+		return ErrLoadPage
+	}
+
+	defer C.fz_drop_page(f.ctx, page)
+
+	device := C.begin_page(f.ctx, wri, C.fz_bound_page(f.ctx, page))
+	if device == nil {
+		return ErrWritePage
+	}
+
+	if C.run_page(f.ctx, page, device, C.fz_identity, nil) == 0 {
+		return ErrRunPageContents
+	}
+
+	if C.end_page(f.ctx, wri) == 0 {
+		return ErrWritePage
+	}
+
+	return nil
+}
+
+// DocumentWriter writes a document composed from the pages of open documents, in the context of one of them.
+type DocumentWriter struct {
+	doc *Document
+	wri *C.fz_document_writer
+	out *outputWriter
+	dev *Device
+	err error
+	mtx sync.Mutex
+}
+
+// Device is the device of a page being written by a DocumentWriter, valid until EndPage.
+type Device struct {
+	w   *DocumentWriter
+	dev *C.fz_device
+}
+
+// NewDocumentWriter returns a writer of a document in format to w, with the formats and options of Convert.
+// The writer works in the context of doc, which must stay open until the writer is closed,
+// the pages written may also be composed from the pages of other documents.
+func NewDocumentWriter(doc *Document, w io.Writer, format string, options string) (*DocumentWriter, error) {
+	format, ok := documentFormat(format)
+	if !ok {
+		return nil, ErrDocumentFormat
+	}
+
+	doc.mtx.Lock()
+	defer doc.mtx.Unlock()
+
+	out, o := newOutput(doc.ctx, w)
+	if out == nil {
+		return nil, ErrCreateOutput
+	}
+
+	cformat := C.CString(format)
+	defer C.free(unsafe.Pointer(cformat))
+
+	coptions := C.CString(options)
+	defer C.free(unsafe.Pointer(coptions))
+
+	// The writer owns out from here on, and drops it even if it cannot be created.
+	wri := C.new_document_writer(doc.ctx, out, cformat, coptions)
+	if wri == nil {
+		releaseOutput(o)
+		return nil, ErrCreateWriter
+	}
+
+	return &DocumentWriter{doc: doc, wri: wri, out: o}, nil
+}
+
+// BeginPage starts a page of the size of mediabox and returns the device to draw it on with Page.Run.
+// It returns nil if the page cannot be started, EndPage and Close then return the error.
+func (w *DocumentWriter) BeginPage(mediabox Rect) *Device {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.wri == nil || w.err != nil {
+		return nil
+	}
+
+	f := w.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	dev := C.begin_page(f.ctx, w.wri, fzRectOf(mediabox))
+	if dev == nil {
+		w.err = ErrWritePage
+		return nil
+	}
+
+	w.dev = &Device{w: w, dev: dev}
+
+	return w.dev
+}
+
+// EndPage finishes the page started by BeginPage.
+func (w *DocumentWriter) EndPage() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.wri == nil {
+		return ErrWriterClosed
+	}
+
+	if w.err != nil {
+		return w.err
+	}
+
+	if w.dev == nil {
+		return ErrDeviceClosed
+	}
+
+	w.dev.dev, w.dev = nil, nil
+
+	f := w.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if C.end_page(f.ctx, w.wri) == 0 {
+		w.err = ErrWritePage
+	}
+
+	return w.err
+}
+
+// Close finishes the document and releases the writer. The document is incomplete if it returns an error.
+func (w *DocumentWriter) Close() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.wri == nil {
+		return ErrWriterClosed
+	}
+
+	f := w.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	err := w.err
+	if err == nil && w.dev != nil {
+		err = ErrWritePage
+	}
+
+	if err == nil && C.close_document_writer(f.ctx, w.wri) == 0 {
+		err = ErrWriteOutput
+	}
+
+	if w.dev != nil {
+		w.dev.dev, w.dev = nil, nil
+	}
+
+	C.fz_drop_document_writer(f.ctx, w.wri)
+
+	w.wri = nil
+
+	if e := releaseOutput(w.out); e != nil {
+		return e
+	}
+
+	return err
+}
+
+// SVG returns svg document for given page number.
+func (f *Document) SVG(pageNumber int) (string, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return "", err
+	}
+
+	defer page.Close()
+
+	return page.SVG()
+}
+
+// ToC returns the table of contents (also known as outline).
+func (f *Document) ToC() ([]Outline, error) {
+	return f.toc(false)
+}
+
+// ToCWithLabels returns the table of contents like ToC, with the label of the page of each entry,
+// which loads the pages the entries point to.
+func (f *Document) ToCWithLabels() ([]Outline, error) {
+	return f.toc(true)
+}
+
+// toc returns the table of contents, with page labels if labels is set.
+func (f *Document) toc(labels bool) ([]Outline, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	data := make([]Outline, 0)
+	pageLabels := make(map[Location]string)
+
+	outline := C.fz_load_outline(f.ctx, f.doc)
+	if outline == nil {
+		return nil, ErrLoadOutline
+	}
+	defer C.fz_drop_outline(f.ctx, outline)
+
+	var walk func(outline *C.fz_outline, level int)
+
+	walk = func(outline *C.fz_outline, level int) {
+		for outline != nil {
+			res := Outline{}
+			res.Level = level
+			res.Title = C.GoString(outline.title)
+			res.URI = C.GoString(outline.uri)
+			res.Page = int(outline.page.page)
+			res.Location = goLocation(outline.page)
+			if labels && res.Location.Page >= 0 {
+				label, ok := pageLabels[res.Location]
+				if !ok {
+					label, _ = f.pageLabel(outline.page)
+					pageLabels[res.Location] = label
+				}
+				res.Label = label
+			}
+			res.Position = Point{X: float64(outline.x), Y: float64(outline.y)}
+			res.Top = res.Position.Y
+			data = append(data, res)
+
+			if outline.down != nil {
+				walk(outline.down, level+1)
+			}
+			outline = outline.next
+		}
+	}
+
+	walk(outline, 1)
+	return data, nil
+}
+
+// Metadata returns the map with standard metadata.
+func (f *Document) Metadata() map[string]string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	data := make(map[string]string)
+
+	data["format"] = f.lookupMetadata("format")
+	data["encryption"] = f.lookupMetadata("encryption")
+	data["title"] = f.lookupMetadata("info:Title")
+	data["author"] = f.lookupMetadata("info:Author")
+	data["subject"] = f.lookupMetadata("info:Subject")
+	data["keywords"] = f.lookupMetadata("info:Keywords")
+	data["creator"] = f.lookupMetadata("info:Creator")
+	data["producer"] = f.lookupMetadata("info:Producer")
+	data["creationDate"] = f.lookupMetadata("info:CreationDate")
+	data["modDate"] = f.lookupMetadata("info:ModDate")
+
+	return data
+}
+
+// Info returns the document information with typed encryption and permission details.
+func (f *Document) Info() Info {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	info := Info{
+		Format:       f.lookupMetadata("format"),
+		Encryption:   f.lookupMetadata("encryption"),
+		Title:        f.lookupMetadata("info:Title"),
+		Author:       f.lookupMetadata("info:Author"),
+		Subject:      f.lookupMetadata("info:Subject"),
+		Keywords:     f.lookupMetadata("info:Keywords"),
+		Creator:      f.lookupMetadata("info:Creator"),
+		Producer:     f.lookupMetadata("info:Producer"),
+		CreationDate: f.lookupMetadata("info:CreationDate"),
+		ModDate:      f.lookupMetadata("info:ModDate"),
+	}
+
+	info.Encrypted = info.Encryption != "" && info.Encryption != "None"
+
+	for _, perm := range permissions {
+		if f.hasPermission(perm.flag) {
+			info.Permissions |= perm.flag
+		}
+	}
+
+	return info
+}
+
+// lookupMetadata returns the metadata value for key up to the first NUL, or an empty string if it is not found.
+func (f *Document) lookupMetadata(key string) string {
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+
+	buf := make([]byte, 256)
+	if C.fz_lookup_metadata(f.ctx, f.doc, ckey, (*C.char)(unsafe.Pointer(&buf[0])), C.size_t(len(buf))) < 0 {
+		return ""
+	}
+
+	return bytePtrToString(&buf[0])
+}
+
+// HasPermission reports whether the document grants all the permissions in p.
+func (f *Document) HasPermission(p Permission) bool {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return f.hasPermission(p)
+}
+
+func (f *Document) hasPermission(p Permission) bool {
+	for _, perm := range permissions {
+		if p&perm.flag != 0 && C.fz_has_permission(f.ctx, f.doc, C.fz_permission(perm.code)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Bound gives the Bounds of a given Page in the document.
+func (f *Document) Bound(pageNumber int) (image.Rectangle, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+
+	defer page.Close()
+
+	return page.Bound()
+}
+
+// PageBox returns the box of given page number in page points.
+func (f *Document) PageBox(pageNumber int, box BoxType) (Rect, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return Rect{}, err
+	}
+
+	defer page.Close()
+
+	return page.Box(box)
+}
+
+// IsReflowable reports whether the document layout depends on the page and font size, as for EPUB, MOBI, FB2 and HTML.
+func (f *Document) IsReflowable() bool {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return C.fz_is_document_reflowable(f.ctx, f.doc) != 0
+}
+
+// Layout lays out a reflowable document in pages of w x h points with a font size of em points, which changes the page count.
+// Pages loaded before must be loaded again, MakeBookmark keeps a reading position across layouts.
+// It returns ErrLayout for fixed layout documents and sizes that are not positive.
+func (f *Document) Layout(w, h, em float64) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if w <= 0 || h <= 0 || em <= 0 || C.fz_is_document_reflowable(f.ctx, f.doc) == 0 {
+		return ErrLayout
+	}
+
+	for _, l := range f.lists.clear() {
+		C.fz_drop_display_list(f.ctx, (*C.fz_display_list)(l))
+	}
+
+	if C.layout_document(f.ctx, f.doc, C.float(w), C.float(h), C.float(em)) == 0 {
+		return ErrLayout
+	}
+
+	return nil
+}
+
+// SetUserCSS sets a stylesheet applied over the styles of reflowable documents, it takes effect on the next Layout.
+func (f *Document) SetUserCSS(css string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	ccss := C.CString(css)
+	defer C.free(unsafe.Pointer(ccss))
+
+	C.fz_set_user_css(f.ctx, ccss)
+}
+
+// MakeBookmark returns a bookmark for given page number, to find the same position with LookupBookmark after Layout.
+func (f *Document) MakeBookmark(pageNumber int) (Bookmark, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if pageNumber >= f.NumPage() {
+		return 0, ErrPageMissing
+	}
+
+	var mark C.fz_bookmark
+	if C.make_bookmark(f.ctx, f.doc, C.int(pageNumber), &mark) == 0 {
+		return 0, ErrBookmark
+	}
+
+	return Bookmark(mark), nil
+}
+
+// LookupBookmark returns the page number of a bookmark in the current layout.
+func (f *Document) LookupBookmark(mark Bookmark) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	pageNumber := int(C.lookup_bookmark(f.ctx, f.doc, C.fz_bookmark(mark)))
+	if pageNumber < 0 {
+		return 0, ErrBookmark
+	}
+
+	return pageNumber, nil
+}
+
+// Close closes the underlying fitz document.
+func (f *Document) Close() error {
+	if f.stream != nil {
+		C.fz_drop_stream(f.ctx, f.stream)
+	}
+
+	for _, l := range f.lists.clear() {
+		C.fz_drop_display_list(f.ctx, (*C.fz_display_list)(l))
+	}
+
+	C.fz_drop_document(f.ctx, f.doc)
+	C.fz_drop_context(f.ctx)
+
+	f.data = nil
+
+	return nil
+}
+
+// Page represents a page loaded from a fitz document, it must be released with Close before the document is closed.
+type Page struct {
+	doc    *Document
+	page   *C.fz_page
+	list   *C.fz_display_list
+	number int
+}
+
+// LoadPage loads the given page number, keeping it parsed between calls.
+func (f *Document) LoadPage(pageNumber int) (*Page, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if pageNumber >= f.NumPage() {
+		return nil, ErrPageMissing
+	}
+
+	page := C.load_page(f.ctx, f.doc, C.int(pageNumber))
+	if page == nil {
+		return nil, ErrLoadPage
+	}
+
+	return &Page{doc: f, page: page, number: pageNumber}, nil
+}
+
+// CountChapters returns the number of chapters in the document.
+func (f *Document) CountChapters() int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return int(C.fz_count_chapters(f.ctx, f.doc))
+}
+
+// CountChapterPages returns the number of pages in the chapter.
+func (f *Document) CountChapterPages(chapter int) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if chapter < 0 || chapter >= int(C.fz_count_chapters(f.ctx, f.doc)) {
+		return 0, ErrPageMissing
+	}
+
+	n := C.count_chapter_pages(f.ctx, f.doc, C.int(chapter))
+	if n < 0 {
+		return 0, ErrLoadPage
+	}
+
+	return int(n), nil
+}
+
+// LoadChapterPage loads the page at loc, without counting the pages of the chapters before it.
+func (f *Document) LoadChapterPage(loc Location) (*Page, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if !f.validLocation(loc) {
+		return nil, ErrPageMissing
+	}
+
+	page := C.load_chapter_page(f.ctx, f.doc, C.int(loc.Chapter), C.int(loc.Page))
+	if page == nil {
+		return nil, ErrLoadPage
+	}
+
+	number := C.page_number_from_location(f.ctx, f.doc, fzLocationOf(loc))
+	if number < 0 {
+		C.fz_drop_page(f.ctx, page)
+		return nil, ErrLoadPage
+	}
+
+	return &Page{doc: f, page: page, number: int(number)}, nil
+}
+
+// NextLocation returns the location of the page after loc, or loc if it is the last page.
+func (f *Document) NextLocation(loc Location) (Location, error) {
+	return f.stepLocation(loc, 0)
+}
+
+// PreviousLocation returns the location of the page before loc, or loc if it is the first page.
+func (f *Document) PreviousLocation(loc Location) (Location, error) {
+	return f.stepLocation(loc, 1)
+}
+
+func (f *Document) stepLocation(loc Location, prev int) (Location, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if !f.validLocation(loc) {
+		return loc, ErrPageMissing
+	}
+
+	cloc := fzLocationOf(loc)
+	if C.step_location(f.ctx, f.doc, &cloc, C.int(prev)) == 0 {
+		return loc, ErrLoadPage
+	}
+
+	return goLocation(cloc), nil
+}
+
+// LocationFromPage returns the location of given page number.
+func (f *Document) LocationFromPage(pageNumber int) (Location, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if pageNumber < 0 || pageNumber >= f.NumPage() {
+		return Location{}, ErrPageMissing
+	}
+
+	var loc C.fz_location
+	if C.location_from_page_number(f.ctx, f.doc, C.int(pageNumber), &loc) == 0 {
+		return Location{}, ErrLoadPage
+	}
+
+	return goLocation(loc), nil
+}
+
+// PageFromLocation returns the page number at loc.
+func (f *Document) PageFromLocation(loc Location) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if !f.validLocation(loc) {
+		return 0, ErrPageMissing
+	}
+
+	number := C.page_number_from_location(f.ctx, f.doc, fzLocationOf(loc))
+	if number < 0 {
+		return 0, ErrPageMissing
+	}
+
+	return int(number), nil
+}
+
+// PageLabel returns the label of given page number, e.g. "xii", or the page number counting from 1 if the document has no page labels.
+func (f *Document) PageLabel(pageNumber int) (string, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return "", err
+	}
+
+	defer page.Close()
+
+	return page.Label()
+}
+
+// PageByLabel returns the number of the first page with the label.
+func (f *Document) PageByLabel(label string) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	number := 0
+	for chapter := 0; chapter < int(C.fz_count_chapters(f.ctx, f.doc)); chapter++ {
+		pages := int(C.count_chapter_pages(f.ctx, f.doc, C.int(chapter)))
+		for page := 0; page < pages; page++ {
+			if l, ok := f.pageLabel(C.fz_location{chapter: C.int(chapter), page: C.int(page)}); ok && l == label {
+				return number, nil
+			}
+			number++
+		}
+	}
+
+	return 0, ErrPageMissing
+}
+
+// pageLabel returns the label of the page at loc.
+func (f *Document) pageLabel(loc C.fz_location) (string, bool) {
+	var buf [pageLabelSize]C.char
+	if C.page_label(f.ctx, f.doc, loc, &buf[0], C.int(len(buf))) == 0 {
+		return "", false
+	}
+
+	return C.GoString(&buf[0]), true
+}
+
+// validLocation reports whether loc addresses a page of the document.
+func (f *Document) validLocation(loc Location) bool {
+	if loc.Chapter < 0 || loc.Chapter >= int(C.fz_count_chapters(f.ctx, f.doc)) || loc.Page < 0 {
+		return false
+	}
+
+	return loc.Page < int(C.count_chapter_pages(f.ctx, f.doc, C.int(loc.Chapter)))
+}
+
+// Number returns the page number.
+func (p *Page) Number() int {
+	return p.number
+}
+
+// Bound gives the Bounds of the page.
+func (p *Page) Bound() (image.Rectangle, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return image.Rectangle{}, ErrPageClosed
+	}
+
+	var bounds C.fz_rect
+	bounds = C.fz_bound_page(f.ctx, p.page)
+
+	return image.Rect(int(bounds.x0), int(bounds.y0), int(bounds.x1), int(bounds.y1)), nil
+}
+
+// Box returns the box of the page in page points, e.g. the trim box, without rounding.
+func (p *Page) Box(box BoxType) (Rect, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return Rect{}, ErrPageClosed
+	}
+
+	var bounds C.fz_rect
+	if C.bound_page_box(f.ctx, p.page, C.int(box.fz()), &bounds) == 0 {
+		return Rect{}, ErrPageBox
+	}
+
+	return goRect(bounds), nil
+}
+
+// Render returns image of the page at the given DPI.
+func (p *Page) Render(dpi float64) (*image.RGBA, error) {
+	img, err := p.RenderWithOptions(RenderOptions{DPI: dpi})
+	if err != nil {
+		return nil, err
+	}
+
+	return img.(*image.RGBA), nil
+}
+
+// RenderWithOptions returns image of the page rendered with the given options.
+func (p *Page) RenderWithOptions(opts RenderOptions) (image.Image, error) {
+	return p.render(opts, nil)
+}
+
+// RenderContext returns image of the page at the given DPI, aborting when ctx is done and reporting to progress if not nil.
+// It returns the image along with ErrPartialRender if errors were encountered while rendering.
+func (p *Page) RenderContext(ctx context.Context, dpi float64, progress ProgressFunc) (*image.RGBA, error) {
+	cookie := newCookie()
+	defer C.free(unsafe.Pointer(cookie))
+
+	stop := watchCookie(ctx, progress, cookie)
+	img, err := p.render(RenderOptions{DPI: dpi}, cookie)
+	stop()
+
+	err = cookieErr(ctx, cookie, err)
+	if img == nil || ctx.Err() != nil {
+		return nil, err
+	}
+
+	return img.(*image.RGBA), err
+}
+
+// render renders the page with the given options, cookie may be nil.
+func (p *Page) render(opts RenderOptions, cookie *C.fz_cookie) (image.Image, error) {
+	f := p.doc
+
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return nil, ErrPageClosed
+	}
+
+	var bounds C.fz_rect
+	bounds = C.fz_bound_page(f.ctx, p.page)
+
+	if opts.Box != BoxCrop && C.bound_page_box(f.ctx, p.page, C.int(opts.Box.fz()), &bounds) == 0 {
+		return nil, ErrPageBox
+	}
+
+	var ctm C.fz_matrix
+	ctm = fzMatrixOf(opts.matrix())
+
+	if opts.rotated() {
+		r := C.fz_transform_rect(bounds, ctm)
+		ctm.e -= r.x0
+		ctm.f -= r.y0
+	}
+
+	if opts.Clip != (Rect{}) {
+		bounds = fzRectOf(goRect(bounds).Intersect(opts.Clip))
+	}
+
+	var bbox C.fz_irect
+	bounds = C.fz_transform_rect(bounds, ctm)
+	bbox = C.fz_round_rect(bounds)
+
+	colorspace, alpha := C.fz_device_rgb(f.ctx), 1
+	switch opts.Colorspace {
+	case ColorspaceGray:
+		colorspace, alpha = C.fz_device_gray(f.ctx), 0
+		if opts.Transparent {
+			alpha = 1
+		}
+	case ColorspaceBGR:
+		colorspace = C.fz_device_bgr(f.ctx)
+	case ColorspaceCMYK:
+		colorspace, alpha = C.fz_device_cmyk(f.ctx), 0
+	}
+
+	pixmap := C.fz_new_pixmap_with_bbox(f.ctx, colorspace, bbox, nil, C.int(alpha))
+	if pixmap == nil {
+		return nil, ErrCreatePixmap
+	}
+
+	if opts.Transparent && alpha == 1 {
+		C.fz_clear_pixmap(f.ctx, pixmap)
+	} else {
+		C.fz_clear_pixmap_with_value(f.ctx, pixmap, C.int(0xff))
+	}
+	defer C.fz_drop_pixmap(f.ctx, pixmap)
+
+	defer f.setAntiAlias(opts)()
+
+	device := C.fz_new_draw_device(f.ctx, ctm, pixmap)
+	C.fz_enable_device_hints(f.ctx, device, C.FZ_NO_CACHE)
+	defer C.fz_drop_device(f.ctx, device)
+
+	drawMatrix := C.fz_identity
+	ret := p.run(device, drawMatrix, cookie)
+	if ret == 0 {
+		return nil, ErrRunPageContents
+	}
+
+	C.fz_close_device(f.ctx, device)
+
+	pixels := C.fz_pixmap_samples(f.ctx, pixmap)
+	if pixels == nil {
+		return nil, ErrPixmapSamples
+	}
+
+	n := int(C.fz_pixmap_components(f.ctx, pixmap))
+	stride := int(C.fz_pixmap_stride(f.ctx, pixmap))
+	rect := image.Rect(int(bbox.x0), int(bbox.y0), int(bbox.x1), int(bbox.y1))
+
+	return pixmapImage(opts.Colorspace, n, rect, stride, unsafe.Slice((*byte)(unsafe.Pointer(pixels)), stride*rect.Dy())), nil
+}
+
+// setAntiAlias sets the anti-aliasing levels of opts, returning a func that restores the previous levels.
+func (f *Document) setAntiAlias(opts RenderOptions) func() {
+	text, graphics := C.fz_text_aa_level(f.ctx), C.fz_graphics_aa_level(f.ctx)
+
+	if bits, ok := aaLevel(opts.TextAntiAlias); ok {
+		C.fz_set_text_aa_level(f.ctx, C.int(bits))
+	}
+
+	if bits, ok := aaLevel(opts.GraphicsAntiAlias); ok {
+		C.fz_set_graphics_aa_level(f.ctx, C.int(bits))
+	}
+
+	return func() {
+		C.fz_set_text_aa_level(f.ctx, text)
+		C.fz_set_graphics_aa_level(f.ctx, graphics)
+	}
+}
+
+// RenderInto renders the page at the given DPI directly into dst, which must have the size of the rendered page.
+func (p *Page) RenderInto(dst *image.RGBA, dpi float64) error {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return ErrPageClosed
+	}
+
+	var bounds C.fz_rect
+	bounds = C.fz_bound_page(f.ctx, p.page)
+
+	var ctm C.fz_matrix
+	ctm = C.fz_scale(C.float(dpi/72), C.float(dpi/72))
+
+	var bbox C.fz_irect
+	bounds = C.fz_transform_rect(bounds, ctm)
+	bbox = C.fz_round_rect(bounds)
+
+	w, h := int(bbox.x1-bbox.x0), int(bbox.y1-bbox.y0)
+	if dst.Rect.Dx() != w || dst.Rect.Dy() != h || dst.Stride != 4*w || len(dst.Pix) < 4*w*h || w*h == 0 {
+		return ErrImageSize
+	}
+
+	var pinner runtime.Pinner
+	pinner.Pin(&dst.Pix[0])
+	defer pinner.Unpin()
+
+	pixmap := C.fz_new_pixmap_with_bbox_and_data(f.ctx, C.fz_device_rgb(f.ctx), bbox, nil, 1, (*C.uchar)(unsafe.Pointer(&dst.Pix[0])))
+	if pixmap == nil {
+		return ErrCreatePixmap
+	}
+
+	C.fz_clear_pixmap_with_value(f.ctx, pixmap, C.int(0xff))
+	defer C.fz_drop_pixmap(f.ctx, pixmap)
+
+	device := C.fz_new_draw_device(f.ctx, ctm, pixmap)
+	C.fz_enable_device_hints(f.ctx, device, C.FZ_NO_CACHE)
+	defer C.fz_drop_device(f.ctx, device)
+
+	drawMatrix := C.fz_identity
+	ret := p.run(device, drawMatrix, nil)
+	if ret == 0 {
+		return ErrRunPageContents
+	}
+
+	C.fz_close_device(f.ctx, device)
+
+	return nil
+}
+
+// tileBounds returns the bounds of the page rendered at the given DPI, recording its display list.
+func (p *Page) tileBounds(dpi float64) (image.Rectangle, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return image.Rectangle{}, ErrPageClosed
+	}
+
+	if p.list == nil {
+		p.list = f.displayList(p.page, p.number, true)
+		if p.list == nil {
+			return image.Rectangle{}, ErrRunPageContents
+		}
+	}
+
+	var bbox C.fz_irect
+	bbox = C.fz_round_rect(C.fz_transform_rect(C.fz_bound_page(f.ctx, p.page), C.fz_scale(C.float(dpi/72), C.float(dpi/72))))
+
+	return image.Rect(int(bbox.x0), int(bbox.y0), int(bbox.x1), int(bbox.y1)), nil
+}
+
+// renderTile returns the part r of the page rendered at the given DPI.
+func (p *Page) renderTile(dpi float64, r image.Rectangle) (image.Image, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return nil, ErrPageClosed
+	}
+
+	bbox := C.fz_irect{x0: C.int(r.Min.X), y0: C.int(r.Min.Y), x1: C.int(r.Max.X), y1: C.int(r.Max.Y)}
+
+	pixmap, err := p.renderBand(C.fz_scale(C.float(dpi/72), C.float(dpi/72)), bbox, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	defer C.fz_drop_pixmap(f.ctx, pixmap)
+
+	stride := int(C.fz_pixmap_stride(f.ctx, pixmap))
+	pixels := unsafe.Slice((*byte)(unsafe.Pointer(C.fz_pixmap_samples(f.ctx, pixmap))), stride*r.Dy())
+
+	return pixmapImage(ColorspaceRGB, 4, r, stride, pixels), nil
+}
+
+// renderBand returns an RGB pixmap of the part bbox of the page, in pixels, replaying its display list.
+func (p *Page) renderBand(ctm C.fz_matrix, bbox C.fz_irect, alpha int) (*C.fz_pixmap, error) {
+	f := p.doc
+
+	pixmap := C.fz_new_pixmap_with_bbox(f.ctx, C.fz_device_rgb(f.ctx), bbox, nil, C.int(alpha))
+	if pixmap == nil {
+		return nil, ErrCreatePixmap
+	}
+
+	C.fz_clear_pixmap_with_value(f.ctx, pixmap, C.int(0xff))
+
+	device := C.fz_new_draw_device(f.ctx, C.fz_identity, pixmap)
+	C.fz_enable_device_hints(f.ctx, device, C.FZ_NO_CACHE)
+	defer C.fz_drop_device(f.ctx, device)
+
+	ret := C.run_display_list(f.ctx, p.list, device, ctm, C.fz_rect_from_irect(bbox), nil)
+	if ret == 0 {
+		C.fz_drop_pixmap(f.ctx, pixmap)
+		return nil, ErrRunPageContents
+	}
+
+	C.fz_close_device(f.ctx, device)
+
+	return pixmap, nil
+}
+
+// WriteImage writes the page rendered at the given DPI to w, encoded in format band by band with bounded memory.
+// It returns ErrImageFormat for formats that cannot be written by bands.
+func (p *Page) WriteImage(dpi float64, format ImageFormat, w io.Writer) error {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return ErrPageClosed
+	}
+
+	if !format.banded() {
+		return ErrImageFormat
+	}
+
+	if p.list == nil {
+		p.list = f.displayList(p.page, p.number, true)
+		if p.list == nil {
+			return ErrRunPageContents
+		}
+	}
+
+	var ctm C.fz_matrix
+	ctm = C.fz_scale(C.float(dpi/72), C.float(dpi/72))
+
+	var bbox C.fz_irect
+	bbox = C.fz_round_rect(C.fz_transform_rect(C.fz_bound_page(f.ctx, p.page), ctm))
+
+	out, o := newOutput(f.ctx, w)
+	if out == nil {
+		return ErrCreateOutput
+	}
+
+	var err error
+
+	writer := C.new_band_writer(f.ctx, out, C.int(format), bbox.x1-bbox.x0, bbox.y1-bbox.y0, 3, C.int(dpi), C.int(p.number), C.fz_device_rgb(f.ctx))
+	if writer == nil {
+		err = ErrWriteOutput
+	} else {
+		for y := bbox.y0; y < bbox.y1 && err == nil; y += imageBandHeight {
+			band := bbox
+			band.y0, band.y1 = y, min(y+imageBandHeight, bbox.y1)
+
+			var pixmap *C.fz_pixmap
+			pixmap, err = p.renderBand(ctm, band, 0)
+			if err != nil {
+				break
+			}
+
+			if C.write_band(f.ctx, writer, pixmap) == 0 {
+				err = ErrWriteOutput
+			}
+
+			C.fz_drop_pixmap(f.ctx, pixmap)
+		}
+
+		if err == nil && C.close_band_writer(f.ctx, writer, out) == 0 {
+			err = ErrWriteOutput
+		}
+
+		C.fz_drop_band_writer(f.ctx, writer)
+	}
+
+	if e := dropOutput(f.ctx, out, o); e != nil {
+		return e
+	}
+
+	return err
+}
+
+// ImageEncoded returns the page rendered at the given DPI and encoded in format. Quality from 1 to 100 applies to JPEG and JPX,
+// 0 selects the default.
+func (p *Page) ImageEncoded(dpi float64, format ImageFormat, quality int) ([]byte, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return nil, ErrPageClosed
+	}
+
+	buf, err := p.encode(dpi, format, quality)
+	if err != nil {
+		return nil, err
+	}
+
+	defer C.fz_drop_buffer(f.ctx, buf)
+
+	var data *C.uchar
+	size := C.fz_buffer_storage(f.ctx, buf, &data)
+
+	return C.GoBytes(unsafe.Pointer(data), C.int(size)), nil
+}
+
+// WriteImageEncoded writes the page rendered at the given DPI and encoded in format to w, see ImageEncoded.
+func (p *Page) WriteImageEncoded(dpi float64, format ImageFormat, quality int, w io.Writer) error {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return ErrPageClosed
+	}
+
+	buf, err := p.encode(dpi, format, quality)
+	if err != nil {
+		return err
+	}
+
+	defer C.fz_drop_buffer(f.ctx, buf)
+
+	var data *C.uchar
+	size := C.fz_buffer_storage(f.ctx, buf, &data)
+
+	_, err = w.Write(unsafe.Slice((*byte)(data), int(size)))
+
+	return err
+}
+
+// encode renders the page at the given DPI into a pixmap suited to format and encodes it, the caller drops the buffer.
+func (p *Page) encode(dpi float64, format ImageFormat, quality int) (*C.fz_buffer, error) {
+	f := p.doc
+
+	var ctm C.fz_matrix
 	ctm = C.fz_scale(C.float(dpi/72), C.float(dpi/72))
 
 	var bbox C.fz_irect
+	bbox = C.fz_round_rect(C.fz_transform_rect(C.fz_bound_page(f.ctx, p.page), ctm))
+
+	cs, alpha := format.pixmap()
+
+	colorspace := C.fz_device_rgb(f.ctx)
+	if cs == ColorspaceCMYK {
+		colorspace = C.fz_device_cmyk(f.ctx)
+	}
+
+	pixmap := C.fz_new_pixmap_with_bbox(f.ctx, colorspace, bbox, nil, C.int(alpha))
+	if pixmap == nil {
+		return nil, ErrCreatePixmap
+	}
+
+	C.fz_clear_pixmap_with_value(f.ctx, pixmap, C.int(0xff))
+	defer C.fz_drop_pixmap(f.ctx, pixmap)
+
+	device := C.fz_new_draw_device(f.ctx, ctm, pixmap)
+	C.fz_enable_device_hints(f.ctx, device, C.FZ_NO_CACHE)
+	defer C.fz_drop_device(f.ctx, device)
+
+	drawMatrix := C.fz_identity
+	ret := p.run(device, drawMatrix, nil)
+	if ret == 0 {
+		return nil, ErrRunPageContents
+	}
+
+	C.fz_close_device(f.ctx, device)
+
+	buf := C.new_buffer_from_pixmap(f.ctx, pixmap, C.int(format), C.int(imageQuality(quality)))
+	if buf == nil {
+		return nil, ErrEncodeImage
+	}
+
+	return buf, nil
+}
+
+// Images returns the images drawn on the page, in drawing order.
+func (p *Page) Images() ([]EmbeddedImage, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return nil, ErrPageClosed
+	}
+
+	var images []EmbeddedImage
+
+	err := p.imageBlocks(func(img *C.fz_image, bbox C.fz_rect) bool {
+		format := "png"
+		if t := C.image_original_type(f.ctx, img); t != 0 {
+			format = C.GoString(C.fz_image_type_name(t))
+		}
+
+		embedded := EmbeddedImage{
+			Rect:       goRect(bbox),
+			Width:      int(img.w),
+			Height:     int(img.h),
+			BPC:        int(img.bpc),
+			Colorspace: C.GoString(C.fz_colorspace_name(f.ctx, img.colorspace)),
+			Format:     format,
+			doc:        f,
+			page:       p.number,
+			index:      len(images),
+		}
+		embedded.XRes, embedded.YRes = imageResolution(embedded.Width, embedded.Height, embedded.Rect)
+
+		images = append(images, embedded)
+
+		return true
+	})
+
+	return images, err
+}
+
+// imageData returns the original or PNG encoded bytes of the image at index in drawing order.
+func (p *Page) imageData(index int) ([]byte, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return nil, ErrPageClosed
+	}
+
+	var data []byte
+	found := false
+	i := 0
+
+	err := p.imageBlocks(func(img *C.fz_image, bbox C.fz_rect) bool {
+		if i < index {
+			i++
+			return true
+		}
+
+		found = true
+
+		var buf *C.fz_buffer
+		if C.image_original_type(f.ctx, img) != 0 {
+			buf = C.fz_compressed_image_buffer(f.ctx, img).buffer
+		} else {
+			buf = C.new_buffer_from_image_as_png(f.ctx, img)
+			if buf == nil {
+				return false
+			}
+
+			defer C.fz_drop_buffer(f.ctx, buf)
+		}
+
+		var ptr *C.uchar
+		size := C.fz_buffer_storage(f.ctx, buf, &ptr)
+		data = C.GoBytes(unsafe.Pointer(ptr), C.int(size))
+
+		return false
+	})
+
+	switch {
+	case err != nil:
+		return nil, err
+	case !found:
+		return nil, ErrImageMissing
+	case data == nil:
+		return nil, ErrEncodeImage
+	}
+
+	return data, nil
+}
+
+// imageBlocks calls fn with each image drawn on the page and its bbox until fn returns false, the images are valid only during the call.
+func (p *Page) imageBlocks(fn func(img *C.fz_image, bbox C.fz_rect) bool) error {
+	f := p.doc
+
+	text, err := p.newSTextPage(TextOptions{PreserveImages: true}, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	defer C.fz_drop_stext_page(f.ctx, text)
+
+	var walk func(blk *C.fz_stext_block) bool
+
+	walk = func(blk *C.fz_stext_block) bool {
+		for ; blk != nil; blk = blk.next {
+			switch blk._type {
+			case C.FZ_STEXT_BLOCK_STRUCT:
+				if down := C.stext_block_down(blk); down != nil && !walk(down.first_block) {
+					return false
+				}
+			case C.FZ_STEXT_BLOCK_IMAGE:
+				if !fn(C.stext_block_image(blk), blk.bbox) {
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+
+	walk(text.first_block)
+
+	return nil
+}
+
+// TextWithOptions returns text of the page, extracted with the given options.
+func (p *Page) TextWithOptions(opts TextOptions) (string, error) {
+	return p.text(opts, nil)
+}
+
+// TextContext returns text of the page, aborting when ctx is done and reporting to progress if not nil.
+// It returns the text along with ErrPartialRender if errors were encountered while extracting it.
+func (p *Page) TextContext(ctx context.Context, progress ProgressFunc) (string, error) {
+	cookie := newCookie()
+	defer C.free(unsafe.Pointer(cookie))
+
+	stop := watchCookie(ctx, progress, cookie)
+	str, err := p.text(TextOptions{}, cookie)
+	stop()
+
+	err = cookieErr(ctx, cookie, err)
+	if ctx.Err() != nil {
+		return "", err
+	}
+
+	return str, err
+}
+
+// text extracts text of the page with the given options, cookie may be nil.
+func (p *Page) text(opts TextOptions, cookie *C.fz_cookie) (string, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return "", ErrPageClosed
+	}
+
+	text, err := p.newSTextPage(opts, 0, cookie)
+	if err != nil {
+		return "", err
+	}
+
+	defer C.fz_drop_stext_page(f.ctx, text)
+
+	buf := C.fz_new_buffer_from_stext_page(f.ctx, text)
+	defer C.fz_drop_buffer(f.ctx, buf)
+
+	str := C.GoString(C.fz_string_from_buffer(f.ctx, buf))
+
+	return str, nil
+}
+
+// Text returns text of the page.
+func (p *Page) Text() (string, error) {
+	return p.TextWithOptions(TextOptions{})
+}
+
+// HTML returns html of the page.
+func (p *Page) HTML(header bool) (string, error) {
+	return p.HTMLWithOptions(header, TextOptions{PreserveImages: true})
+}
+
+// HTMLWithOptions returns html of the page, extracted with the given options.
+func (p *Page) HTMLWithOptions(header bool, opts TextOptions) (string, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return "", ErrPageClosed
+	}
+
+	text, err := p.newSTextPage(opts, 0, nil)
+	if err != nil {
+		return "", err
+	}
+
+	defer C.fz_drop_stext_page(f.ctx, text)
+
+	buf := C.fz_new_buffer(f.ctx, 1024)
+	defer C.fz_drop_buffer(f.ctx, buf)
+
+	out := C.fz_new_output_with_buffer(f.ctx, buf)
+	defer C.fz_drop_output(f.ctx, out)
+
+	if header {
+		C.fz_print_stext_header_as_html(f.ctx, out)
+	}
+	C.fz_print_stext_page_as_html(f.ctx, out, text, C.int(p.number))
+	if header {
+		C.fz_print_stext_trailer_as_html(f.ctx, out)
+	}
+
+	C.fz_close_output(f.ctx, out)
+
+	str := C.GoString(C.fz_string_from_buffer(f.ctx, buf))
+
+	return str, nil
+}
+
+// SVG returns svg document of the page.
+func (p *Page) SVG() (string, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return "", ErrPageClosed
+	}
+
+	var bounds C.fz_rect
+	bounds = C.fz_bound_page(f.ctx, p.page)
+
+	var ctm C.fz_matrix
+	ctm = C.fz_scale(C.float(72.0/72), C.float(72.0/72))
 	bounds = C.fz_transform_rect(bounds, ctm)
-	bbox = C.fz_round_rect(bounds)
 
-	pixmap := C.fz_new_pixmap_with_bbox(f.ctx, C.fz_device_rgb(f.ctx), bbox, nil, 1)
-	if pixmap == nil {
-		return nil, ErrCreatePixmap
-	}
+	buf := C.fz_new_buffer(f.ctx, 1024)
+	defer C.fz_drop_buffer(f.ctx, buf)
 
-	C.fz_clear_pixmap_with_value(f.ctx, pixmap, C.int(0xff))
-	defer C.fz_drop_pixmap(f.ctx, pixmap)
+	out := C.fz_new_output_with_buffer(f.ctx, buf)
+	defer C.fz_drop_output(f.ctx, out)
 
-	device := C.fz_new_draw_device(f.ctx, ctm, pixmap)
+	device := C.fz_new_svg_device(f.ctx, out, bounds.x1-bounds.x0, bounds.y1-bounds.y0, C.FZ_SVG_TEXT_AS_PATH, 1)
 	C.fz_enable_device_hints(f.ctx, device, C.FZ_NO_CACHE)
 	defer C.fz_drop_device(f.ctx, device)
 
-	drawMatrix := C.fz_identity
-	ret := C.run_page_contents(f.ctx, page, device, drawMatrix, nil)
+	var cookie C.fz_cookie
+	ret := p.run(device, ctm, &cookie)
 	if ret == 0 {
-		return nil, ErrRunPageContents
+		return "", ErrRunPageContents
 	}
 
 	C.fz_close_device(f.ctx, device)
+	C.fz_close_output(f.ctx, out)
 
-	pixels := C.fz_pixmap_samples(f.ctx, pixmap)
-	if pixels == nil {
-		return nil, ErrPixmapSamples
-	}
-
-	img := image.NewRGBA(image.Rect(int(bbox.x0), int(bbox.y0), int(bbox.x1), int(bbox.y1)))
-	copy(img.Pix, C.GoBytes(unsafe.Pointer(pixels), C.int(4*bbox.x1*bbox.y1)))
+	str := C.GoString(C.fz_string_from_buffer(f.ctx, buf))
 
-	return img, nil
+	return str, nil
 }
 
-// ImagePNG returns image for given page number as PNG bytes.
-func (f *Document) ImagePNG(pageNumber int, dpi float64) ([]byte, error) {
-	f.mtx.Lock()
-	defer f.mtx.Unlock()
-
-	if pageNumber >= f.NumPage() {
-		return nil, ErrPageMissing
+// Run draws the page with its annotations on dev, transformed by m from page space to the space of the page being written.
+// A page of another document than the one of the writer is copied into the writer as a one-page PDF,
+// which keeps its graphics, text and images but not its links.
+func (p *Page) Run(dev *Device, m Matrix) error {
+	if dev == nil {
+		return ErrDeviceClosed
 	}
 
-	page := C.load_page(f.ctx, f.doc, C.int(pageNumber))
-	if page == nil {
-		return nil, ErrLoadPage
-	}
+	w := dev.w
 
-	defer C.fz_drop_page(f.ctx, page)
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
 
-	var bounds C.fz_rect
-	bounds = C.fz_bound_page(f.ctx, page)
+	if dev.dev == nil {
+		return ErrDeviceClosed
+	}
 
-	var ctm C.fz_matrix
-	ctm = C.fz_scale(C.float(dpi/72), C.float(dpi/72))
+	if p.doc == w.doc {
+		f := p.doc
 
-	var bbox C.fz_irect
-	bounds = C.fz_transform_rect(bounds, ctm)
-	bbox = C.fz_round_rect(bounds)
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
 
-	pixmap := C.fz_new_pixmap_with_bbox(f.ctx, C.fz_device_rgb(f.ctx), bbox, nil, 1)
-	if pixmap == nil {
-		return nil, ErrCreatePixmap
-	}
+		if p.page == nil {
+			return ErrPageClosed
+		}
 
-	C.fz_clear_pixmap_with_value(f.ctx, pixmap, C.int(0xff))
-	defer C.fz_drop_pixmap(f.ctx, pixmap)
+		if C.run_page(f.ctx, p.page, dev.dev, fzMatrixOf(m), nil) == 0 {
+			return ErrRunPageContents
+		}
 
-	device := C.fz_new_draw_device(f.ctx, ctm, pixmap)
-	C.fz_enable_device_hints(f.ctx, device, C.FZ_NO_CACHE)
-	defer C.fz_drop_device(f.ctx, device)
+		return nil
+	}
 
-	drawMatrix := C.fz_identity
-	ret := C.run_page_contents(f.ctx, page, device, drawMatrix, nil)
-	if ret == 0 {
-		return nil, ErrRunPageContents
+	data, bounds, err := p.pdf()
+	if err != nil {
+		return err
 	}
 
-	C.fz_close_device(f.ctx, device)
+	f := w.doc
 
-	buf := C.fz_new_buffer_from_pixmap_as_png(f.ctx, pixmap, C.fz_default_color_params)
-	defer C.fz_drop_buffer(f.ctx, buf)
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
 
-	size := C.fz_buffer_storage(f.ctx, buf, nil)
-	str := C.GoStringN(C.fz_string_from_buffer(f.ctx, buf), C.int(size))
+	if C.run_pdf_page(f.ctx, (*C.uchar)(unsafe.Pointer(&data[0])), C.size_t(len(data)), bounds, dev.dev, fzMatrixOf(m)) == 0 {
+		return ErrRunPageContents
+	}
 
-	return []byte(str), nil
+	return nil
 }
 
-// Links returns slice of links for given page number.
-func (f *Document) Links(pageNumber int) ([]Link, error) {
+// pdf returns the page written as a one-page PDF in the context of its document, and its bounds.
+func (p *Page) pdf() ([]byte, C.fz_rect, error) {
+	f := p.doc
+
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
 
-	if pageNumber >= f.NumPage() {
-		return nil, ErrPageMissing
+	if p.page == nil {
+		return nil, C.fz_rect{}, ErrPageClosed
 	}
 
-	page := C.load_page(f.ctx, f.doc, C.int(pageNumber))
-	if page == nil {
-		return nil, ErrLoadPage
+	buf := C.new_buffer_from_page_as_pdf(f.ctx, p.page)
+	if buf == nil {
+		return nil, C.fz_rect{}, ErrRunPageContents
 	}
 
-	defer C.fz_drop_page(f.ctx, page)
+	defer C.fz_drop_buffer(f.ctx, buf)
+
+	var data *C.uchar
+	size := C.fz_buffer_storage(f.ctx, buf, &data)
+
+	return C.GoBytes(unsafe.Pointer(data), C.int(size)), C.fz_bound_page(f.ctx, p.page), nil
+}
+
+// Links returns slice of links on the page.
+func (p *Page) Links() ([]Link, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
 
-	links := C.fz_load_links(f.ctx, page)
+	if p.page == nil {
+		return nil, ErrPageClosed
+	}
+
+	links := C.fz_load_links(f.ctx, p.page)
 	defer C.fz_drop_link(f.ctx, links)
 
 	linkCount := 0
@@ -435,263 +2918,257 @@ func (f *Document) Links(pageNumber int) ([]Link, error) {
 	currLink := links
 	for i := 0; i < linkCount; i++ {
 		gLinks[i] = Link{
-			URI: C.GoString(currLink.uri),
+			URI:  C.GoString(currLink.uri),
+			Rect: goRect(currLink.rect),
+		}
+
+		if C.fz_is_external_link(f.ctx, currLink.uri) != 0 {
+			gLinks[i].External = true
+			gLinks[i].Dest.Page = -1
+		} else {
+			var dest C.fz_link_dest
+			gLinks[i].Dest = LinkDest{
+				Page:    int(C.resolve_link_dest(f.ctx, f.doc, currLink.uri, &dest)),
+				Chapter: int(dest.loc.chapter),
+				X:       float64(dest.x),
+				Y:       float64(dest.y),
+				Zoom:    float64(dest.zoom),
+				Type:    LinkDestType(dest._type),
+			}
 		}
+
 		currLink = currLink.next
 	}
 
 	return gLinks, nil
 }
 
-// Text returns text for given page number.
-func (f *Document) Text(pageNumber int) (string, error) {
+// Label returns the label of the page, e.g. "xii", or the page number counting from 1 if the document has no page labels.
+func (p *Page) Label() (string, error) {
+	f := p.doc
+
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
 
-	if pageNumber >= f.NumPage() {
-		return "", ErrPageMissing
+	if p.page == nil {
+		return "", ErrPageClosed
 	}
 
-	page := C.load_page(f.ctx, f.doc, C.int(pageNumber))
-	if page == nil {
-		return "", ErrLoadPage
+	var buf [pageLabelSize]C.char
+	if C.label_page(f.ctx, p.page, &buf[0], C.int(len(buf))) == 0 {
+		return "", ErrPageLabel
 	}
 
-	defer C.fz_drop_page(f.ctx, page)
-
-	var bounds C.fz_rect
-	bounds = C.fz_bound_page(f.ctx, page)
-
-	var ctm C.fz_matrix
-	ctm = C.fz_scale(C.float(72.0/72), C.float(72.0/72))
+	return C.GoString(&buf[0]), nil
+}
 
-	text := C.fz_new_stext_page(f.ctx, bounds)
-	defer C.fz_drop_stext_page(f.ctx, text)
+// Close releases the page.
+func (p *Page) Close() error {
+	p.doc.mtx.Lock()
+	defer p.doc.mtx.Unlock()
 
-	var opts C.fz_stext_options
-	opts.flags = 0
+	p.drop()
 
-	device := C.fz_new_stext_device(f.ctx, text, &opts)
-	C.fz_enable_device_hints(f.ctx, device, C.FZ_NO_CACHE)
-	defer C.fz_drop_device(f.ctx, device)
+	return nil
+}
 
-	var cookie C.fz_cookie
-	ret := C.run_page_contents(f.ctx, page, device, ctm, &cookie)
-	if ret == 0 {
-		return "", ErrRunPageContents
+// drop releases the page, the caller holds the document mutex.
+func (p *Page) drop() {
+	if p.page != nil {
+		C.fz_drop_display_list(p.doc.ctx, p.list)
+		C.fz_drop_page(p.doc.ctx, p.page)
+		p.list = nil
+		p.page = nil
 	}
-
-	C.fz_close_device(f.ctx, device)
-
-	buf := C.fz_new_buffer_from_stext_page(f.ctx, text)
-	defer C.fz_drop_buffer(f.ctx, buf)
-
-	str := C.GoString(C.fz_string_from_buffer(f.ctx, buf))
-
-	return str, nil
 }
 
-// HTML returns html for given page number.
-func (f *Document) HTML(pageNumber int, header bool) (string, error) {
+// DisplayList records the page once into a display list, that later renders, text extraction and SVG output of the page replay.
+// The list is kept until the page is closed, and shared with the display list cache of the document.
+func (p *Page) DisplayList() error {
+	f := p.doc
+
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
 
-	if pageNumber >= f.NumPage() {
-		return "", ErrPageMissing
+	if p.page == nil {
+		return ErrPageClosed
 	}
 
-	page := C.load_page(f.ctx, f.doc, C.int(pageNumber))
-	if page == nil {
-		return "", ErrLoadPage
+	if p.list == nil {
+		p.list = f.displayList(p.page, p.number, true)
+		if p.list == nil {
+			return ErrRunPageContents
+		}
 	}
 
-	defer C.fz_drop_page(f.ctx, page)
+	return nil
+}
 
-	var bounds C.fz_rect
-	bounds = C.fz_bound_page(f.ctx, page)
+// newSTextPage extracts the structured text of the page with opts and the extra fz_stext_options flags, replaying its
+// display list when one is recorded or cached, cookie may be nil. The caller holds the document mutex and drops the text.
+func (p *Page) newSTextPage(opts TextOptions, flags int, cookie *C.fz_cookie) (*C.fz_stext_page, error) {
+	f := p.doc
 
-	var ctm C.fz_matrix
-	ctm = C.fz_scale(C.float(72.0/72), C.float(72.0/72))
+	sopts, err := f.stextOptions(opts)
+	if err != nil {
+		return nil, err
+	}
 
-	text := C.fz_new_stext_page(f.ctx, bounds)
-	defer C.fz_drop_stext_page(f.ctx, text)
+	sopts.flags |= C.int(flags)
 
-	var opts C.fz_stext_options
-	opts.flags = C.FZ_STEXT_PRESERVE_IMAGES
+	text := C.fz_new_stext_page(f.ctx, C.fz_bound_page(f.ctx, p.page))
 
-	device := C.fz_new_stext_device(f.ctx, text, &opts)
+	device := C.fz_new_stext_device(f.ctx, text, &sopts)
 	C.fz_enable_device_hints(f.ctx, device, C.FZ_NO_CACHE)
 	defer C.fz_drop_device(f.ctx, device)
 
-	var cookie C.fz_cookie
-	ret := C.run_page_contents(f.ctx, page, device, ctm, &cookie)
+	ret := p.run(device, C.fz_identity, cookie)
 	if ret == 0 {
-		return "", ErrRunPageContents
+		C.fz_drop_stext_page(f.ctx, text)
+		return nil, ErrRunPageContents
 	}
 
 	C.fz_close_device(f.ctx, device)
 
-	buf := C.fz_new_buffer(f.ctx, 1024)
-	defer C.fz_drop_buffer(f.ctx, buf)
+	return text, nil
+}
 
-	out := C.fz_new_output_with_buffer(f.ctx, buf)
-	defer C.fz_drop_output(f.ctx, out)
+// run runs the page on device, replaying its display list when one is recorded or cached.
+func (p *Page) run(device *C.fz_device, ctm C.fz_matrix, cookie *C.fz_cookie) C.int {
+	f := p.doc
 
-	if header {
-		C.fz_print_stext_header_as_html(f.ctx, out)
-	}
-	C.fz_print_stext_page_as_html(f.ctx, out, text, C.int(pageNumber))
-	if header {
-		C.fz_print_stext_trailer_as_html(f.ctx, out)
+	if p.list == nil {
+		p.list = f.displayList(p.page, p.number, false)
 	}
 
-	C.fz_close_output(f.ctx, out)
-
-	str := C.GoString(C.fz_string_from_buffer(f.ctx, buf))
+	if p.list != nil {
+		return C.run_display_list(f.ctx, p.list, device, ctm, C.fz_infinite_rect, cookie)
+	}
 
-	return str, nil
+	return C.run_page_contents(f.ctx, p.page, device, ctm, cookie)
 }
 
-// SVG returns svg document for given page number.
-func (f *Document) SVG(pageNumber int) (string, error) {
-	f.mtx.Lock()
-	defer f.mtx.Unlock()
-
-	if pageNumber >= f.NumPage() {
-		return "", ErrPageMissing
+// displayList returns a new reference to the display list of page, from the cache or recorded and cached within its capacity.
+// Without a cache it records only when force is set, and returns nil otherwise.
+func (f *Document) displayList(page *C.fz_page, pageNumber int, force bool) *C.fz_display_list {
+	if l := f.lists.get(pageNumber); l != nil {
+		return C.fz_keep_display_list(f.ctx, (*C.fz_display_list)(l))
 	}
 
-	page := C.load_page(f.ctx, f.doc, C.int(pageNumber))
-	if page == nil {
-		return "", ErrLoadPage
+	if f.lists.capacity <= 0 && !force {
+		return nil
 	}
 
-	defer C.fz_drop_page(f.ctx, page)
-
-	var bounds C.fz_rect
-	bounds = C.fz_bound_page(f.ctx, page)
-
-	var ctm C.fz_matrix
-	ctm = C.fz_scale(C.float(72.0/72), C.float(72.0/72))
-	bounds = C.fz_transform_rect(bounds, ctm)
-
-	buf := C.fz_new_buffer(f.ctx, 1024)
-	defer C.fz_drop_buffer(f.ctx, buf)
-
-	out := C.fz_new_output_with_buffer(f.ctx, buf)
-	defer C.fz_drop_output(f.ctx, out)
-
-	device := C.fz_new_svg_device(f.ctx, out, bounds.x1-bounds.x0, bounds.y1-bounds.y0, C.FZ_SVG_TEXT_AS_PATH, 1)
-	C.fz_enable_device_hints(f.ctx, device, C.FZ_NO_CACHE)
-	defer C.fz_drop_device(f.ctx, device)
-
-	var cookie C.fz_cookie
-	ret := C.run_page_contents(f.ctx, page, device, ctm, &cookie)
-	if ret == 0 {
-		return "", ErrRunPageContents
+	list := C.new_display_list(f.ctx, page)
+	if list == nil {
+		return nil
 	}
 
-	C.fz_close_device(f.ctx, device)
-	C.fz_close_output(f.ctx, out)
-
-	str := C.GoString(C.fz_string_from_buffer(f.ctx, buf))
+	if f.lists.capacity > 0 {
+		C.fz_keep_display_list(f.ctx, list)
+		for _, l := range f.lists.add(pageNumber, unsafe.Pointer(list)) {
+			C.fz_drop_display_list(f.ctx, (*C.fz_display_list)(l))
+		}
+	}
 
-	return str, nil
+	return list
 }
 
-// ToC returns the table of contents (also known as outline).
-func (f *Document) ToC() ([]Outline, error) {
-	data := make([]Outline, 0)
+// SetDisplayListCache sets the number of page display lists cached by the document, 0 disables the cache.
+// The cache is bounded by a number of pages rather than bytes, as MuPDF does not report the memory a display list holds.
+func (f *Document) SetDisplayListCache(n int) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
 
-	outline := C.fz_load_outline(f.ctx, f.doc)
-	if outline == nil {
-		return nil, ErrLoadOutline
+	for _, l := range f.lists.setCapacity(n) {
+		C.fz_drop_display_list(f.ctx, (*C.fz_display_list)(l))
 	}
-	defer C.fz_drop_outline(f.ctx, outline)
+}
 
-	var walk func(outline *C.fz_outline, level int)
+func goPoint(p C.fz_point) Point {
+	return Point{X: float64(p.x), Y: float64(p.y)}
+}
 
-	walk = func(outline *C.fz_outline, level int) {
-		for outline != nil {
-			res := Outline{}
-			res.Level = level
-			res.Title = C.GoString(outline.title)
-			res.URI = C.GoString(outline.uri)
-			res.Page = int(outline.page.page)
-			res.Top = float64(outline.y)
-			data = append(data, res)
+func goRect(r C.fz_rect) Rect {
+	return Rect{X0: float64(r.x0), Y0: float64(r.y0), X1: float64(r.x1), Y1: float64(r.y1)}
+}
 
-			if outline.down != nil {
-				walk(outline.down, level+1)
-			}
-			outline = outline.next
-		}
-	}
+func goLocation(loc C.fz_location) Location {
+	return Location{Chapter: int(loc.chapter), Page: int(loc.page)}
+}
 
-	walk(outline, 1)
-	return data, nil
+func fzLocationOf(loc Location) C.fz_location {
+	return C.fz_location{chapter: C.int(loc.Chapter), page: C.int(loc.Page)}
 }
 
-// Metadata returns the map with standard metadata.
-func (f *Document) Metadata() map[string]string {
-	data := make(map[string]string)
+func goQuad(q C.fz_quad) Quad {
+	return Quad{UL: goPoint(q.ul), UR: goPoint(q.ur), LL: goPoint(q.ll), LR: goPoint(q.lr)}
+}
 
-	lookup := func(key string) string {
-		ckey := C.CString(key)
-		defer C.free(unsafe.Pointer(ckey))
+// newCookie returns a zeroed fz_cookie in C memory, that a watching goroutine can update while MuPDF runs.
+func newCookie() *C.fz_cookie {
+	return (*C.fz_cookie)(C.calloc(1, C.sizeof_fz_cookie))
+}
 
-		buf := make([]byte, 256)
-		C.fz_lookup_metadata(f.ctx, f.doc, ckey, (*C.char)(unsafe.Pointer(&buf[0])), C.size_t(len(buf)))
+// watchCookie aborts the cookie when ctx is done and reports its progress to fn, until the returned stop func is called.
+func watchCookie(ctx context.Context, fn ProgressFunc, cookie *C.fz_cookie) func() {
+	return watch(ctx, fn, func() {
+		cookie.abort = 1
+	}, func() (int, int) {
+		return int(cookie.progress), int(cookie.progress_max)
+	})
+}
 
-		return string(buf)
+// cookieErr returns the error of a run with cookie: err, the error of ctx if it aborted, or ErrPartialRender.
+func cookieErr(ctx context.Context, cookie *C.fz_cookie, err error) error {
+	switch {
+	case err != nil:
+		return err
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case cookie.errors > 0:
+		return ErrPartialRender
 	}
 
-	data["format"] = lookup("format")
-	data["encryption"] = lookup("encryption")
-	data["title"] = lookup("info:Title")
-	data["author"] = lookup("info:Author")
-	data["subject"] = lookup("info:Subject")
-	data["keywords"] = lookup("info:Keywords")
-	data["creator"] = lookup("info:Creator")
-	data["producer"] = lookup("info:Producer")
-	data["creationDate"] = lookup("info:CreationDate")
-	data["modDate"] = lookup("info:ModDate")
-
-	return data
+	return nil
 }
 
-// Bound gives the Bounds of a given Page in the document.
-func (f *Document) Bound(pageNumber int) (image.Rectangle, error) {
-	f.mtx.Lock()
-	defer f.mtx.Unlock()
-
-	if pageNumber >= f.NumPage() {
-		return image.Rectangle{}, ErrPageMissing
-	}
+// newOutput returns an fz_output that writes to w, it must be released with dropOutput.
+func newOutput(ctx *C.fz_context, w io.Writer) (*C.fz_output, *outputWriter) {
+	o := &outputWriter{w: w}
+	o.handle = uintptr(cgo.NewHandle(o))
 
-	page := C.load_page(f.ctx, f.doc, C.int(pageNumber))
-	if page == nil {
-		return image.Rectangle{}, ErrLoadPage
+	out := C.new_output_with_writer(ctx, C.uintptr_t(o.handle))
+	if out == nil {
+		cgo.Handle(o.handle).Delete()
+		return nil, nil
 	}
 
-	defer C.fz_drop_page(f.ctx, page)
+	return out, o
+}
 
-	var bounds C.fz_rect
-	bounds = C.fz_bound_page(f.ctx, page)
+// dropOutput releases the fz_output and returns the first error from its writer.
+func dropOutput(ctx *C.fz_context, out *C.fz_output, o *outputWriter) error {
+	C.fz_drop_output(ctx, out)
 
-	return image.Rect(int(bounds.x0), int(bounds.y0), int(bounds.x1), int(bounds.y1)), nil
+	return releaseOutput(o)
 }
 
-// Close closes the underlying fitz document.
-func (f *Document) Close() error {
-	if f.stream != nil {
-		C.fz_drop_stream(f.ctx, f.stream)
-	}
+// releaseOutput releases the writer of an fz_output dropped by its owner and returns its first error.
+func releaseOutput(o *outputWriter) error {
+	cgo.Handle(o.handle).Delete()
 
-	C.fz_drop_document(f.ctx, f.doc)
-	C.fz_drop_context(f.ctx)
+	return o.err
+}
 
-	f.data = nil
+func fzPointOf(p Point) C.fz_point {
+	return C.fz_point{x: C.float(p.X), y: C.float(p.Y)}
+}
 
-	return nil
+func fzRectOf(r Rect) C.fz_rect {
+	return C.fz_rect{x0: C.float(r.X0), y0: C.float(r.Y0), x1: C.float(r.X1), y1: C.float(r.Y1)}
+}
+
+func fzMatrixOf(m Matrix) C.fz_matrix {
+	return C.fz_matrix{a: C.float(m.A), b: C.float(m.B), c: C.float(m.C), d: C.float(m.D), e: C.float(m.E), f: C.float(m.F)}
 }
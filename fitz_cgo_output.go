@@ -0,0 +1,20 @@
+//go:build cgo && !nocgo
+
+package fitz
+
+/*
+#include <stdint.h>
+#include <mupdf/fitz.h>
+*/
+import "C"
+
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+//export goWriteOutput
+func goWriteOutput(ctx *C.fz_context, state C.uintptr_t, data unsafe.Pointer, n C.size_t) {
+	o := cgo.Handle(state).Value().(*outputWriter)
+	o.write(unsafe.Slice((*byte)(data), int(n)))
+}
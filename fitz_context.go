@@ -0,0 +1,48 @@
+package fitz
+
+import (
+	"context"
+	"time"
+)
+
+// progressInterval is how often the progress of a running page is reported.
+const progressInterval = 50 * time.Millisecond
+
+// ProgressFunc is called periodically with the progress of a running page, out of max (0 if unknown).
+type ProgressFunc func(progress, max int)
+
+// watch calls abort when ctx is done and reports the progress to fn, if not nil, until the returned stop func is called.
+func watch(ctx context.Context, fn ProgressFunc, abort func(), progress func() (int, int)) (stop func()) {
+	if ctx.Done() == nil && fn == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+
+	go func() {
+		defer close(exited)
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				abort()
+				return
+			case <-ticker.C:
+				if fn != nil {
+					fn(progress())
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-exited
+	}
+}
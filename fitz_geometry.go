@@ -0,0 +1,232 @@
+package fitz
+
+import (
+	"image"
+	"math"
+)
+
+// Point type.
+type Point struct {
+	X, Y float64
+}
+
+// Rect type.
+type Rect struct {
+	X0, Y0, X1, Y1 float64
+}
+
+// Quad type, a four-cornered shape, typically a rotated rectangle.
+type Quad struct {
+	UL, UR, LL, LR Point
+}
+
+// Matrix type, an affine transform mapping a point (x, y) to (x*A + y*C + E, x*B + y*D + F).
+type Matrix struct {
+	A, B, C, D, E, F float64
+}
+
+// Bounds of infinite rectangles, as in MuPDF.
+const (
+	minInfRect = -2147483648
+	maxInfRect = 0x7fffff80
+)
+
+// fltEpsilon is FLT_EPSILON, used to match the float precision of MuPDF.
+const fltEpsilon = 1.19209290e-07
+
+// Identity is the identity matrix.
+var Identity = Matrix{A: 1, D: 1}
+
+// InfiniteRect contains all points.
+var InfiniteRect = Rect{X0: minInfRect, Y0: minInfRect, X1: maxInfRect, Y1: maxInfRect}
+
+// Scale returns a matrix scaling by sx and sy.
+func Scale(sx, sy float64) Matrix {
+	return Matrix{A: sx, D: sy}
+}
+
+// Translate returns a matrix translating by tx and ty.
+func Translate(tx, ty float64) Matrix {
+	return Matrix{A: 1, D: 1, E: tx, F: ty}
+}
+
+// Rotate returns a matrix rotating clockwise by degrees, exact for multiples of 90.
+func Rotate(degrees float64) Matrix {
+	degrees = math.Mod(degrees, 360)
+	if degrees < 0 {
+		degrees += 360
+	}
+
+	var s, c float64
+
+	switch {
+	case math.Abs(degrees) < fltEpsilon:
+		s, c = 0, 1
+	case math.Abs(90-degrees) < fltEpsilon:
+		s, c = 1, 0
+	case math.Abs(180-degrees) < fltEpsilon:
+		s, c = 0, -1
+	case math.Abs(270-degrees) < fltEpsilon:
+		s, c = -1, 0
+	default:
+		s, c = math.Sincos(degrees * math.Pi / 180)
+	}
+
+	return Matrix{A: c, B: s, C: -s, D: c}
+}
+
+// Concat returns the matrix applying m, then n.
+func (m Matrix) Concat(n Matrix) Matrix {
+	return Matrix{
+		A: m.A*n.A + m.B*n.C,
+		B: m.A*n.B + m.B*n.D,
+		C: m.C*n.A + m.D*n.C,
+		D: m.C*n.B + m.D*n.D,
+		E: m.E*n.A + m.F*n.C + n.E,
+		F: m.E*n.B + m.F*n.D + n.F,
+	}
+}
+
+// Invert returns the inverse of m, or m if it is degenerate.
+func (m Matrix) Invert() Matrix {
+	det := m.A*m.D - m.B*m.C
+	if det > -fltEpsilon && det < fltEpsilon {
+		return m
+	}
+
+	r := 1 / det
+	i := Matrix{A: m.D * r, B: -m.B * r, C: -m.C * r, D: m.A * r}
+	i.E = -m.E*i.A - m.F*i.C
+	i.F = -m.E*i.B - m.F*i.D
+
+	return i
+}
+
+// Transform returns p transformed by m.
+func (p Point) Transform(m Matrix) Point {
+	return Point{X: p.X*m.A + p.Y*m.C + m.E, Y: p.X*m.B + p.Y*m.D + m.F}
+}
+
+// IsEmpty reports whether r contains no points.
+func (r Rect) IsEmpty() bool {
+	return r.X0 >= r.X1 || r.Y0 >= r.Y1
+}
+
+// IsInfinite reports whether r is InfiniteRect.
+func (r Rect) IsInfinite() bool {
+	return r == InfiniteRect
+}
+
+// Intersect returns the largest rectangle contained in both r and s.
+func (r Rect) Intersect(s Rect) Rect {
+	if s.IsInfinite() {
+		return r
+	}
+
+	if r.IsInfinite() {
+		return s
+	}
+
+	return Rect{X0: max(r.X0, s.X0), Y0: max(r.Y0, s.Y0), X1: min(r.X1, s.X1), Y1: min(r.Y1, s.Y1)}
+}
+
+// Union returns the smallest rectangle containing both r and s.
+func (r Rect) Union(s Rect) Rect {
+	switch {
+	case r.IsInfinite():
+		return r
+	case s.IsInfinite():
+		return s
+	case r.IsEmpty():
+		return s
+	case s.IsEmpty():
+		return r
+	}
+
+	return Rect{X0: min(r.X0, s.X0), Y0: min(r.Y0, s.Y0), X1: max(r.X1, s.X1), Y1: max(r.Y1, s.Y1)}
+}
+
+// Transform returns the bounding box of r transformed by m.
+func (r Rect) Transform(m Matrix) Rect {
+	if r.IsInfinite() {
+		return r
+	}
+
+	if math.Abs(m.B) < fltEpsilon && math.Abs(m.C) < fltEpsilon {
+		if m.A < 0 {
+			r.X0, r.X1 = r.X1, r.X0
+		}
+		if m.D < 0 {
+			r.Y0, r.Y1 = r.Y1, r.Y0
+		}
+
+		p, q := Point{r.X0, r.Y0}.Transform(m), Point{r.X1, r.Y1}.Transform(m)
+
+		return Rect{X0: p.X, Y0: p.Y, X1: q.X, Y1: q.Y}
+	} else if math.Abs(m.A) < fltEpsilon && math.Abs(m.D) < fltEpsilon {
+		if m.B < 0 {
+			r.X0, r.X1 = r.X1, r.X0
+		}
+		if m.C < 0 {
+			r.Y0, r.Y1 = r.Y1, r.Y0
+		}
+
+		p, q := Point{r.X0, r.Y0}.Transform(m), Point{r.X1, r.Y1}.Transform(m)
+
+		return Rect{X0: p.X, Y0: p.Y, X1: q.X, Y1: q.Y}
+	}
+
+	invalid := r.X0 > r.X1 || r.Y0 > r.Y1
+	s := Point{r.X0, r.Y0}.Transform(m)
+	t := Point{r.X0, r.Y1}.Transform(m)
+	u := Point{r.X1, r.Y1}.Transform(m)
+	v := Point{r.X1, r.Y0}.Transform(m)
+
+	r = Rect{
+		X0: min(s.X, t.X, u.X, v.X),
+		Y0: min(s.Y, t.Y, u.Y, v.Y),
+		X1: max(s.X, t.X, u.X, v.X),
+		Y1: max(s.Y, t.Y, u.Y, v.Y),
+	}
+
+	if invalid {
+		r.X0, r.X1 = r.X1, r.X0
+		r.Y0, r.Y1 = r.Y1, r.Y0
+	}
+
+	return r
+}
+
+// Round returns the smallest integer rectangle containing r, ignoring a difference of 0.001 as MuPDF does.
+func (r Rect) Round() image.Rectangle {
+	const minSafe, maxSafe = -16777216, 16777216
+
+	clamp := func(f float64) int {
+		return int(min(max(f, minSafe), maxSafe))
+	}
+
+	return image.Rectangle{
+		Min: image.Point{X: clamp(math.Floor(r.X0 + 0.001)), Y: clamp(math.Floor(r.Y0 + 0.001))},
+		Max: image.Point{X: clamp(math.Ceil(r.X1 - 0.001)), Y: clamp(math.Ceil(r.Y1 - 0.001))},
+	}
+}
+
+// ToImageRect returns the pixel rectangle covered by r in page points when the page is rendered at dpi.
+func (r Rect) ToImageRect(dpi float64) image.Rectangle {
+	return r.Transform(Scale(dpi/72, dpi/72)).Round()
+}
+
+// Transform returns q transformed by m.
+func (q Quad) Transform(m Matrix) Quad {
+	return Quad{UL: q.UL.Transform(m), UR: q.UR.Transform(m), LL: q.LL.Transform(m), LR: q.LR.Transform(m)}
+}
+
+// Rect returns the bounding box of q.
+func (q Quad) Rect() Rect {
+	return Rect{
+		X0: min(q.UL.X, q.UR.X, q.LL.X, q.LR.X),
+		Y0: min(q.UL.Y, q.UR.Y, q.LL.Y, q.LR.Y),
+		X1: max(q.UL.X, q.UR.X, q.LL.X, q.LR.X),
+		Y1: max(q.UL.Y, q.UR.Y, q.LL.Y, q.LR.Y),
+	}
+}
@@ -0,0 +1,61 @@
+package fitz
+
+// EmbeddedImage is an image drawn on a page.
+type EmbeddedImage struct {
+	// Rect is the area covered by the image on the page, in page points.
+	Rect Rect
+	// Width and Height of the image in pixels.
+	Width  int
+	Height int
+	// BPC is the number of bits per color component.
+	BPC int
+	// Colorspace is the name of the image colorspace, e.g. DeviceRGB, or None for stencil masks.
+	Colorspace string
+	// XRes and YRes are the resolution of the image as drawn on the page, in dots per inch.
+	XRes float64
+	YRes float64
+	// Format is the encoding returned by Data, e.g. jpeg, jpx or jbig2 for the original bytes, or png if re-encoded.
+	Format string
+
+	doc   *Document
+	page  int
+	index int
+}
+
+// Data returns the original compressed bytes of the image, or the image encoded as PNG
+// if its compression is not a standalone image format.
+func (i *EmbeddedImage) Data() ([]byte, error) {
+	page, err := i.doc.LoadPage(i.page)
+	if err != nil {
+		return nil, err
+	}
+
+	defer page.Close()
+
+	return page.imageData(i.index)
+}
+
+// Images returns the images drawn on given page number, in drawing order.
+func (f *Document) Images(pageNumber int) ([]EmbeddedImage, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	defer page.Close()
+
+	return page.Images()
+}
+
+// imageResolution returns the resolution of an image w x h pixels drawn in r.
+func imageResolution(w, h int, r Rect) (xres, yres float64) {
+	if dx := r.X1 - r.X0; dx > 0 {
+		xres = float64(w) * 72 / dx
+	}
+
+	if dy := r.Y1 - r.Y0; dy > 0 {
+		yres = float64(h) * 72 / dy
+	}
+
+	return xres, yres
+}
@@ -3,15 +3,17 @@
 package fitz
 
 import (
+	"context"
 	"image"
 	"io"
-	"math"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 
 	"github.com/ebitengine/purego"
@@ -24,11 +26,13 @@ type Document struct {
 	doc    *fzDocument
 	mtx    sync.Mutex
 	stream *fzStream
+	lists  displayListCache
 }
 
 // New returns new fitz document.
 func New(filename string) (f *Document, err error) {
 	f = &Document{}
+	f.lists.capacity = MaxDisplayLists
 
 	filename, err = filepath.Abs(filename)
 	if err != nil {
@@ -71,6 +75,7 @@ func NewFromMemory(b []byte) (f *Document, err error) {
 		return nil, ErrEmptyBytes
 	}
 	f = &Document{}
+	f.lists.capacity = MaxDisplayLists
 
 	f.ctx = fzNewContextImp(nil, nil, uint64(MaxStore), FzVersion)
 	if f.ctx == nil {
@@ -99,6 +104,7 @@ func NewFromMemory(b []byte) (f *Document, err error) {
 	f.doc = fzOpenDocumentWithStream(f.ctx, magic, f.stream)
 	if f.doc == nil {
 		err = ErrOpenDocument
+		return
 	}
 
 	ret := fzNeedsPassword(f.ctx, f.doc)
@@ -123,6 +129,49 @@ func NewFromReader(r io.Reader) (f *Document, err error) {
 	return
 }
 
+// NewWithPassword returns new fitz document, authenticated with the given password.
+func NewWithPassword(filename, password string) (f *Document, err error) {
+	f, err = New(filename)
+	if err != ErrNeedsPassword {
+		return
+	}
+
+	if _, err = f.Authenticate(password); err != nil {
+		f.Close()
+		f = nil
+	}
+
+	return
+}
+
+// NewFromMemoryWithPassword returns new fitz document from byte slice, authenticated with the given password.
+func NewFromMemoryWithPassword(b []byte, password string) (f *Document, err error) {
+	f, err = NewFromMemory(b)
+	if err != ErrNeedsPassword {
+		return
+	}
+
+	if _, err = f.Authenticate(password); err != nil {
+		f.Close()
+		f = nil
+	}
+
+	return
+}
+
+// Authenticate unlocks an encrypted document with the user or owner password.
+func (f *Document) Authenticate(password string) (AuthResult, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	ret := fzAuthenticatePassword(f.ctx, f.doc, password)
+	if ret == 0 {
+		return 0, ErrWrongPassword
+	}
+
+	return AuthResult(ret), nil
+}
+
 // NumPage returns total number of pages in document.
 func (f *Document) NumPage() int {
 	return fzCountPages(f.ctx, f.doc)
@@ -130,7 +179,7 @@ func (f *Document) NumPage() int {
 
 // Image returns the page at its native resolution (300 DPI if it has no images), cropped to a single full-page image.
 func (f *Document) Image(pageNumber int) (*image.RGBA, error) {
-	dpi, x0, y0, x1, y1, crop := f.pageInfo(pageNumber)
+	dpi, box, crop := f.pageInfo(pageNumber)
 	if dpi <= 0 {
 		dpi = 300.0
 	}
@@ -141,14 +190,14 @@ func (f *Document) Image(pageNumber int) (*image.RGBA, error) {
 	}
 
 	if crop {
-		img = cropImage(img, x0, y0, x1, y1, dpi)
+		img = cropImage(img, box, dpi)
 	}
 
 	return img, nil
 }
 
 // pageInfo returns the native resolution and, for a single full-page image, its bbox (points) with crop=true.
-func (f *Document) pageInfo(pageNumber int) (dpi, x0, y0, x1, y1 float64, crop bool) {
+func (f *Document) pageInfo(pageNumber int) (dpi float64, box Rect, crop bool) {
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
 
@@ -180,7 +229,6 @@ func (f *Document) pageInfo(pageNumber int) (dpi, x0, y0, x1, y1 float64, crop b
 	fzDropDevice(f.ctx, device)
 
 	var barea float64
-	var box fzRect
 	hasText := false
 
 	for blk := text.FirstBlock; blk != nil; blk = blk.Next {
@@ -192,7 +240,7 @@ func (f *Document) pageInfo(pageNumber int) (dpi, x0, y0, x1, y1 float64, crop b
 			continue
 		}
 
-		img := *(**fzImage)(unsafe.Pointer(&blk.U[24]))
+		img := blk.image()
 		bw := float64(blk.Bbox.X1 - blk.Bbox.X0)
 		bh := float64(blk.Bbox.Y1 - blk.Bbox.Y0)
 		if bw > 0 {
@@ -207,73 +255,128 @@ func (f *Document) pageInfo(pageNumber int) (dpi, x0, y0, x1, y1 float64, crop b
 		}
 		if bw*bh > barea {
 			barea = bw * bh
-			box = blk.Bbox
+			box = goRect(blk.Bbox)
 		}
 	}
 
 	crop = !hasText && parea > 0 && barea >= 0.5*parea
 
-	return dpi, float64(box.X0), float64(box.Y0), float64(box.X1), float64(box.Y1), crop
+	return dpi, box, crop
 }
 
 // ImageDPI returns image for given page number and DPI.
 func (f *Document) ImageDPI(pageNumber int, dpi float64) (*image.RGBA, error) {
-	f.mtx.Lock()
-	defer f.mtx.Unlock()
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return nil, err
+	}
 
-	if pageNumber >= f.NumPage() {
-		return nil, ErrPageMissing
+	defer page.Close()
+
+	return page.Render(dpi)
+}
+
+// Render returns image for given page number, rendered with the given options.
+// It is an *image.RGBA, *image.Gray, *image.CMYK or *BGRA depending on the colorspace.
+func (f *Document) Render(pageNumber int, opts RenderOptions) (image.Image, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return nil, err
 	}
 
-	page := fzLoadPage(f.ctx, f.doc, pageNumber)
-	if page == nil {
-		return nil, ErrLoadPage
+	defer page.Close()
+
+	return page.RenderWithOptions(opts)
+}
+
+// RenderInto renders given page number at the given DPI directly into dst, avoiding allocations.
+// The dst image must have the size of the rendered page, as returned by ImageDPI.
+func (f *Document) RenderInto(pageNumber int, dst *image.RGBA, dpi float64) error {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return err
 	}
 
-	defer fzDropPage(f.ctx, page)
+	defer page.Close()
 
-	var bounds fzRect
-	bounds = boundPage(f.ctx, page)
+	return page.RenderInto(dst, dpi)
+}
 
-	var ctm fzMatrix
-	ctm = scale(float32(dpi/72), float32(dpi/72))
+// ImageDPIContext returns image for given page number and DPI, aborting when ctx is done and reporting to progress if not nil.
+// It returns the image along with ErrPartialRender if errors were encountered while rendering.
+func (f *Document) ImageDPIContext(ctx context.Context, pageNumber int, dpi float64, progress ProgressFunc) (*image.RGBA, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return nil, err
+	}
 
-	var bbox fzIRect
-	bounds = transformRect(bounds, ctm)
-	bbox = roundRect(bounds)
+	defer page.Close()
 
-	pixmap := fzNewPixmap(f.ctx, fzDeviceRgb(f.ctx), int(bbox.X1), int(bbox.Y1), nil, 1)
-	if pixmap == nil {
-		return nil, ErrCreatePixmap
+	return page.RenderContext(ctx, dpi, progress)
+}
+
+// Links returns slice of links for given page number.
+func (f *Document) Links(pageNumber int) ([]Link, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return nil, err
 	}
 
-	fzClearPixmapWithValue(f.ctx, pixmap, 0xff)
-	defer fzDropPixmap(f.ctx, pixmap)
+	defer page.Close()
 
-	device := newDrawDevice(f.ctx, ctm, pixmap)
-	fzEnableDeviceHints(f.ctx, device, fzNoCache)
-	defer fzDropDevice(f.ctx, device)
+	return page.Links()
+}
 
-	runPageContents(f.ctx, page, device, fzIdentity)
+// Text returns text for given page number.
+func (f *Document) Text(pageNumber int) (string, error) {
+	return f.TextWithOptions(pageNumber, TextOptions{})
+}
 
-	fzCloseDevice(f.ctx, device)
+// TextContext returns text for given page number, aborting when ctx is done and reporting to progress if not nil.
+// It returns the text along with ErrPartialRender if errors were encountered while extracting it.
+func (f *Document) TextContext(ctx context.Context, pageNumber int, progress ProgressFunc) (string, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return "", err
+	}
 
-	pixels := fzPixmapSamples(f.ctx, pixmap)
-	if pixels == nil {
-		return nil, ErrPixmapSamples
+	defer page.Close()
+
+	return page.TextContext(ctx, progress)
+}
+
+// TextWithOptions returns text for given page number, extracted with the given options.
+func (f *Document) TextWithOptions(pageNumber int, opts TextOptions) (string, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return "", err
 	}
 
-	img := image.NewRGBA(image.Rect(int(bbox.X0), int(bbox.Y0), int(bbox.X1), int(bbox.Y1)))
-	copy(img.Pix, unsafe.Slice(pixels, 4*bbox.X1*bbox.Y1))
+	defer page.Close()
 
-	return img, nil
+	return page.TextWithOptions(opts)
 }
 
-// ImagePNG returns image for given page number as PNG bytes.
-func (f *Document) ImagePNG(pageNumber int, dpi float64) ([]byte, error) {
-	f.mtx.Lock()
-	defer f.mtx.Unlock()
+// stextOptions returns the fz_stext_options for opts.
+func (f *Document) stextOptions(opts TextOptions) (fzStextOptions, error) {
+	var sopts fzStextOptions
+
+	if err := opts.check(); err != nil {
+		return sopts, err
+	}
 
+	if opts.Options != "" {
+		fzParseStextOptions(f.ctx, &sopts, opts.Options)
+	}
+
+	sopts.Flags |= int32(opts.flags())
+
+	return sopts, nil
+}
+
+// newSTextPage loads given page number and extracts its structured text, see (*Page).newSTextPage.
+// The caller holds the document mutex and drops the text.
+func (f *Document) newSTextPage(pageNumber int, opts TextOptions, flags int) (*fzStextPage, error) {
 	if pageNumber >= f.NumPage() {
 		return nil, ErrPageMissing
 	}
@@ -283,234 +386,663 @@ func (f *Document) ImagePNG(pageNumber int, dpi float64) ([]byte, error) {
 		return nil, ErrLoadPage
 	}
 
-	defer fzDropPage(f.ctx, page)
-
-	var bounds fzRect
-	bounds = boundPage(f.ctx, page)
+	p := &Page{doc: f, page: page, number: pageNumber}
+	defer p.drop()
 
-	var ctm fzMatrix
-	ctm = scale(float32(dpi/72), float32(dpi/72))
+	return p.newSTextPage(opts, flags, nil)
+}
 
-	var bbox fzIRect
-	bounds = transformRect(bounds, ctm)
-	bbox = roundRect(bounds)
+// StructuredText returns the blocks, lines, spans and characters for given page number.
+func (f *Document) StructuredText(pageNumber int, opts TextOptions) (*TextPage, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
 
-	pixmap := fzNewPixmap(f.ctx, fzDeviceRgb(f.ctx), int(bbox.X1), int(bbox.Y1), nil, 1)
-	if pixmap == nil {
-		return nil, ErrCreatePixmap
+	text, err := f.newSTextPage(pageNumber, opts, 0)
+	if err != nil {
+		return nil, err
 	}
 
-	fzClearPixmapWithValue(f.ctx, pixmap, 0xff)
-	defer fzDropPixmap(f.ctx, pixmap)
+	defer fzDropStextPage(f.ctx, text)
 
-	device := newDrawDevice(f.ctx, ctm, pixmap)
-	fzEnableDeviceHints(f.ctx, device, fzNoCache)
-	defer fzDropDevice(f.ctx, device)
+	return f.textPage(text), nil
+}
 
-	runPageContents(f.ctx, page, device, fzIdentity)
+// textPage converts the fz_stext_page to a TextPage.
+func (f *Document) textPage(text *fzStextPage) *TextPage {
+	fonts := make(map[*fzFont]string)
 
-	fzCloseDevice(f.ctx, device)
+	tp := &TextPage{Mediabox: goRect(text.Mediabox)}
 
-	params := fzColorParams{1, 1, 0, 0}
-	buf := newBufferFromPixmapAsPNG(f.ctx, pixmap, params)
-	defer fzDropBuffer(f.ctx, buf)
+	var walk func(blk *fzStextBlock)
 
-	size := fzBufferStorage(f.ctx, buf, nil)
+	walk = func(blk *fzStextBlock) {
+		for ; blk != nil; blk = blk.Next {
+			block := TextBlock{Type: TextBlockType(blk.Type), BBox: goRect(blk.Bbox)}
 
-	ret := make([]byte, size)
-	copy(ret, unsafe.Slice(fzStringFromBuffer(f.ctx, buf), size))
+			switch blk.Type {
+			case fzStextBlockStruct:
+				if down := blk.down(); down != nil {
+					walk(down.FirstBlock)
+				}
+				continue
+			case fzStextBlockText:
+				for ln := blk.firstLine(); ln != nil; ln = ln.Next {
+					line := TextLine{WMode: int(ln.Wmode), Dir: goPoint(ln.Dir), BBox: goRect(ln.Bbox)}
+					for ch := ln.FirstChar; ch != nil; ch = ch.Next {
+						font, ok := fonts[ch.Font]
+						if !ok {
+							font = bytePtrToString(fzFontName(f.ctx, ch.Font))
+							fonts[ch.Font] = font
+						}
+
+						c := TextChar{Rune: rune(ch.C), Origin: goPoint(ch.Origin), Quad: goQuad(ch.Quad), Bidi: int(ch.Bidi)}
+						line.addChar(c, font, float64(ch.Size), ch.Argb, CharFlags(ch.Flags))
+					}
+					block.Lines = append(block.Lines, line)
+				}
+			case fzStextBlockImage:
+				img := blk.image()
+				block.ImageWidth, block.ImageHeight = int(img.W), int(img.H)
+			}
 
-	return ret, nil
+			tp.Blocks = append(tp.Blocks, block)
+		}
+	}
+
+	walk(text.FirstBlock)
+
+	return tp
 }
 
-// Links returns slice of links for given page number.
-func (f *Document) Links(pageNumber int) ([]Link, error) {
+// TextInRect returns the text for given page number inside the rectangle r, in page points.
+func (f *Document) TextInRect(pageNumber int, r Rect) (string, error) {
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
 
 	if pageNumber >= f.NumPage() {
-		return nil, ErrPageMissing
+		return "", ErrPageMissing
 	}
 
-	page := fzLoadPage(f.ctx, f.doc, pageNumber)
-	if page == nil {
-		return nil, ErrLoadPage
+	text, err := f.newSTextPage(pageNumber, TextOptions{}, 0)
+	if err != nil {
+		return "", err
 	}
 
-	defer fzDropPage(f.ctx, page)
-
-	links := fzLoadLinks(f.ctx, page)
-	defer fzDropLink(f.ctx, links)
-
-	linkCount := 0
-	for currLink := links; currLink != nil; currLink = currLink.Next {
-		linkCount++
-	}
+	defer fzDropStextPage(f.ctx, text)
 
-	if linkCount == 0 {
-		return nil, nil
+	str := copyRectangle(f.ctx, text, fzRectOf(r))
+	if str == nil {
+		return "", ErrCopyText
 	}
 
-	gLinks := make([]Link, linkCount)
-
-	currLink := links
-	for i := 0; i < linkCount; i++ {
-		gLinks[i] = Link{
-			URI: bytePtrToString((*uint8)(unsafe.Pointer(currLink.Uri))),
-		}
-		currLink = currLink.Next
-	}
+	defer fzFree(f.ctx, str)
 
-	return gLinks, nil
+	return bytePtrToString(str), nil
 }
 
-// Text returns text for given page number.
-func (f *Document) Text(pageNumber int) (string, error) {
+// SelectText returns the text for given page number selected from point a to point b, in page points,
+// along with the quads to highlight it.
+func (f *Document) SelectText(pageNumber int, a, b Point) (string, []Quad, error) {
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
 
 	if pageNumber >= f.NumPage() {
-		return "", ErrPageMissing
+		return "", nil, ErrPageMissing
 	}
 
-	page := fzLoadPage(f.ctx, f.doc, pageNumber)
-	if page == nil {
-		return "", ErrLoadPage
+	text, err := f.newSTextPage(pageNumber, TextOptions{}, 0)
+	if err != nil {
+		return "", nil, err
 	}
 
-	defer fzDropPage(f.ctx, page)
-
-	var bounds fzRect
-	bounds = boundPage(f.ctx, page)
-
-	var ctm fzMatrix
-	ctm = scale(float32(72.0/72), float32(72.0/72))
-
-	text := newStextPage(f.ctx, bounds)
 	defer fzDropStextPage(f.ctx, text)
 
-	var opts fzStextOptions
-	opts.Flags = 0
-
-	device := fzNewStextDevice(f.ctx, text, &opts)
-	fzEnableDeviceHints(f.ctx, device, fzNoCache)
-	defer fzDropDevice(f.ctx, device)
+	pa, pb := fzPointOf(a), fzPointOf(b)
+	snapSelection(f.ctx, text, &pa, &pb, fzSelectChars)
 
-	runPageContents(f.ctx, page, device, ctm)
+	quads := make([]fzQuad, selectMaxQuads)
+	n := highlightSelection(f.ctx, text, pa, pb, quads)
 
-	fzCloseDevice(f.ctx, device)
+	str := copySelection(f.ctx, text, pa, pb)
+	if str == nil {
+		return "", nil, ErrCopyText
+	}
 
-	buf := fzNewBufferFromStextPage(f.ctx, text)
-	defer fzDropBuffer(f.ctx, buf)
+	defer fzFree(f.ctx, str)
 
-	ret := fzStringFromBuffer(f.ctx, buf)
+	hits := make([]Quad, n)
+	for i := range hits {
+		hits[i] = goQuad(quads[i])
+	}
 
-	return bytePtrToString(ret), nil
+	return bytePtrToString(str), hits, nil
 }
 
-// HTML returns html for given page number.
-func (f *Document) HTML(pageNumber int, header bool) (string, error) {
+// Paragraphs returns the paragraphs for given page number in reading order, segmenting the page into regions such as columns.
+func (f *Document) Paragraphs(pageNumber int) ([]Paragraph, error) {
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
 
 	if pageNumber >= f.NumPage() {
-		return "", ErrPageMissing
+		return nil, ErrPageMissing
 	}
 
-	page := fzLoadPage(f.ctx, f.doc, pageNumber)
-	if page == nil {
-		return "", ErrLoadPage
+	text, err := f.newSTextPage(pageNumber, TextOptions{}, fzStextDehyphenate|fzStextSegment|fzStextParagraphBreak)
+	if err != nil {
+		return nil, err
 	}
 
-	defer fzDropPage(f.ctx, page)
+	defer fzDropStextPage(f.ctx, text)
 
-	var bounds fzRect
-	bounds = boundPage(f.ctx, page)
+	var data []Paragraph
+	var regions int
+
+	var walk func(blk *fzStextBlock, region int, regionBBox Rect)
+
+	walk = func(blk *fzStextBlock, region int, regionBBox Rect) {
+		for ; blk != nil; blk = blk.Next {
+			switch blk.Type {
+			case fzStextBlockText:
+				var b strings.Builder
+				for ln := blk.firstLine(); ln != nil; ln = ln.Next {
+					for ch := ln.FirstChar; ch != nil; ch = ch.Next {
+						b.WriteRune(rune(ch.C))
+					}
+					if ln.Next != nil && ln.Flags&fzStextLineFlagsJoined == 0 {
+						b.WriteByte(' ')
+					}
+				}
+
+				data = append(data, Paragraph{Text: b.String(), BBox: goRect(blk.Bbox), Region: region, RegionBBox: regionBBox})
+			case fzStextBlockStruct:
+				if down := blk.down(); down != nil {
+					regions++
+					walk(down.FirstBlock, regions, goRect(blk.Bbox))
+				}
+			}
+		}
+	}
 
-	var ctm fzMatrix
-	ctm = scale(float32(72.0/72), float32(72.0/72))
+	walk(text.FirstBlock, 0, goRect(text.Mediabox))
+
+	return data, nil
+}
+
+// Tables returns the tables found on given page number.
+func (f *Document) Tables(pageNumber int) ([]Table, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if pageNumber >= f.NumPage() {
+		return nil, ErrPageMissing
+	}
+
+	text, err := f.newSTextPage(pageNumber, TextOptions{}, fzStextCollectVectors|fzStextTableHunt)
+	if err != nil {
+		return nil, err
+	}
 
-	text := newStextPage(f.ctx, bounds)
 	defer fzDropStextPage(f.ctx, text)
 
-	var opts fzStextOptions
-	opts.Flags = fzStextPreserveImages
+	return tables(text.FirstBlock, nil), nil
+}
 
-	device := fzNewStextDevice(f.ctx, text, &opts)
-	fzEnableDeviceHints(f.ctx, device, fzNoCache)
-	defer fzDropDevice(f.ctx, device)
+// tables appends the tables found by the table hunt in the blocks starting at blk.
+func tables(blk *fzStextBlock, ts []Table) []Table {
+	for ; blk != nil; blk = blk.Next {
+		if blk.Type != fzStextBlockStruct {
+			continue
+		}
 
-	runPageContents(f.ctx, page, device, ctm)
+		down := blk.down()
+		if down == nil {
+			continue
+		}
 
-	fzCloseDevice(f.ctx, device)
+		if down.Standard != fzStructureTable {
+			ts = tables(down.FirstBlock, ts)
+			continue
+		}
 
-	buf := fzNewBuffer(f.ctx, 1024)
-	defer fzDropBuffer(f.ctx, buf)
+		t := Table{BBox: goRect(blk.Bbox)}
+		for tr := down.FirstBlock; tr != nil; tr = tr.Next {
+			row := tr.down()
+			if tr.Type != fzStextBlockStruct || row == nil || row.Standard != fzStructureTR {
+				continue
+			}
 
-	out := fzNewOutputWithBuffer(f.ctx, buf)
-	defer fzDropOutput(f.ctx, out)
+			var cells []TableCell
+			for td := row.FirstBlock; td != nil; td = td.Next {
+				cell := td.down()
+				if td.Type != fzStextBlockStruct || cell == nil || (cell.Standard != fzStructureTD && cell.Standard != fzStructureTH) {
+					continue
+				}
 
-	if header {
-		fzPrintStextHeaderAsHTML(f.ctx, out)
-	}
-	fzPrintStextPageAsHTML(f.ctx, out, text, pageNumber)
-	if header {
-		fzPrintStextTrailerAsHTML(f.ctx, out)
+				cells = append(cells, TableCell{BBox: goRect(td.Bbox), Text: strings.Join(blockLines(cell.FirstBlock, nil), "\n")})
+			}
+			t.Rows = append(t.Rows, cells)
+		}
+
+		ts = append(ts, t)
 	}
 
-	fzCloseOutput(f.ctx, out)
+	return ts
+}
 
-	ret := fzStringFromBuffer(f.ctx, buf)
+// blockLines appends the text of each line in the blocks starting at blk, descending into structure blocks.
+func blockLines(blk *fzStextBlock, lines []string) []string {
+	for ; blk != nil; blk = blk.Next {
+		switch blk.Type {
+		case fzStextBlockText:
+			for ln := blk.firstLine(); ln != nil; ln = ln.Next {
+				var b strings.Builder
+				for ch := ln.FirstChar; ch != nil; ch = ch.Next {
+					b.WriteRune(rune(ch.C))
+				}
+				lines = append(lines, b.String())
+			}
+		case fzStextBlockStruct:
+			if down := blk.down(); down != nil {
+				lines = blockLines(down.FirstBlock, lines)
+			}
+		}
+	}
 
-	return bytePtrToString(ret), nil
+	return lines
 }
 
-// SVG returns svg document for given page number.
-func (f *Document) SVG(pageNumber int) (string, error) {
+// SearchWithOptions returns the hit quads of a search for needle on given page number, at most maxHits.
+func (f *Document) SearchWithOptions(pageNumber int, needle string, maxHits int, opts SearchOptions) ([]Quad, error) {
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
 
 	if pageNumber >= f.NumPage() {
-		return "", ErrPageMissing
+		return nil, ErrPageMissing
 	}
 
-	page := fzLoadPage(f.ctx, f.doc, pageNumber)
-	if page == nil {
-		return "", ErrLoadPage
+	if maxHits <= 0 || needle == "" {
+		return nil, nil
 	}
 
-	defer fzDropPage(f.ctx, page)
+	return f.search(pageNumber, needle, maxHits, opts, false)
+}
 
-	var bounds fzRect
-	bounds = boundPage(f.ctx, page)
+// search returns the hit quads of a search for needle on given page number, at most maxHits, or all of them if all is set
+// by growing the buffer while it fills up. The caller holds the document mutex.
+func (f *Document) search(pageNumber int, needle string, maxHits int, opts SearchOptions, all bool) ([]Quad, error) {
+	if err := opts.check(needle); err != nil {
+		return nil, err
+	}
 
-	var ctm fzMatrix
-	ctm = scale(float32(72.0/72), float32(72.0/72))
-	bounds = transformRect(bounds, ctm)
+	text, err := f.newSTextPage(pageNumber, TextOptions{}, 0)
+	if err != nil {
+		return nil, err
+	}
 
-	buf := fzNewBuffer(f.ctx, 1024)
-	defer fzDropBuffer(f.ctx, buf)
+	defer fzDropStextPage(f.ctx, text)
 
-	out := fzNewOutputWithBuffer(f.ctx, buf)
-	defer fzDropOutput(f.ctx, out)
+	for {
+		marks := make([]int32, maxHits)
+		quads := make([]fzQuad, maxHits)
 
-	device := newSvgDevice(f.ctx, out, bounds.X1-bounds.X0, bounds.Y1-bounds.Y0, fzSvgTextAsPath, 1)
-	fzEnableDeviceHints(f.ctx, device, fzNoCache)
-	defer fzDropDevice(f.ctx, device)
+		n := fzMatchStextPage(f.ctx, text, needle, unsafe.SliceData(marks), unsafe.SliceData(quads), maxHits, opts.flags())
+		if n < 0 {
+			return nil, ErrSearch
+		}
+
+		if all && n == maxHits {
+			maxHits *= 2
+			continue
+		}
 
-	runPageContents(f.ctx, page, device, ctm)
+		hits := make([]Quad, n)
+		for i := range hits {
+			hits[i] = goQuad(quads[i])
+		}
+
+		return hits, nil
+	}
+}
+
+// HTML returns html for given page number.
+func (f *Document) HTML(pageNumber int, header bool) (string, error) {
+	return f.HTMLWithOptions(pageNumber, header, TextOptions{PreserveImages: true})
+}
+
+// HTMLWithOptions returns html for given page number, extracted with the given options.
+func (f *Document) HTMLWithOptions(pageNumber int, header bool, opts TextOptions) (string, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return "", err
+	}
+
+	defer page.Close()
+
+	return page.HTMLWithOptions(header, opts)
+}
+
+// TextAs writes the text for given page number to w in the given format.
+func (f *Document) TextAs(pageNumber int, format TextFormat, w io.Writer) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if pageNumber >= f.NumPage() {
+		return ErrPageMissing
+	}
+
+	text, err := f.newSTextPage(pageNumber, format.textOptions(), 0)
+	if err != nil {
+		return err
+	}
+
+	defer fzDropStextPage(f.ctx, text)
+
+	out, o := newOutput(f.ctx, w)
+	if out == nil {
+		return ErrCreateOutput
+	}
+
+	switch format {
+	case TextFormatHTML:
+		fzPrintStextHeaderAsHTML(f.ctx, out)
+		fzPrintStextPageAsHTML(f.ctx, out, text, pageNumber)
+		fzPrintStextTrailerAsHTML(f.ctx, out)
+	case TextFormatXHTML:
+		fzPrintStextHeaderAsXHTML(f.ctx, out)
+		fzPrintStextPageAsXHTML(f.ctx, out, text, pageNumber)
+		fzPrintStextTrailerAsXHTML(f.ctx, out)
+	case TextFormatXML:
+		fzPrintStextPageAsXML(f.ctx, out, text, pageNumber)
+	case TextFormatJSON:
+		fzPrintStextPageAsJSON(f.ctx, out, text, 1)
+	default:
+		fzPrintStextPageAsText(f.ctx, out, text)
+	}
 
-	fzCloseDevice(f.ctx, device)
 	fzCloseOutput(f.ctx, out)
 
-	ret := fzStringFromBuffer(f.ctx, buf)
+	return dropOutput(f.ctx, out, o)
+}
 
-	return bytePtrToString(ret), nil
+// ConvertContext is like Convert, aborting between pages when ctx is done.
+// It calls progress, if not nil, after each page with the number of pages written, out of the pages to write.
+func ConvertContext(ctx context.Context, src *Document, w io.Writer, format string, options string, pages []int, progress ProgressFunc) error {
+	format, ok := documentFormat(format)
+	if !ok {
+		return ErrDocumentFormat
+	}
+
+	src.mtx.Lock()
+	defer src.mtx.Unlock()
+
+	pages, err := convertPages(pages, src.NumPage())
+	if err != nil {
+		return err
+	}
+
+	out, o := newOutput(src.ctx, w)
+	if out == nil {
+		return ErrCreateOutput
+	}
+
+	// The writer owns out from here on, and drops it even if it cannot be created.
+	wri := fzNewDocumentWriterWithOutput(src.ctx, out, format, options)
+	if wri == nil {
+		releaseOutput(o)
+		return ErrCreateWriter
+	}
+
+	for i, number := range pages {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+
+		if err = src.writePage(wri, number); err != nil {
+			break
+		}
+
+		if progress != nil {
+			progress(i+1, len(pages))
+		}
+	}
+
+	if err == nil {
+		err = closeDocumentWriter(src.ctx, wri)
+	}
+
+	fzDropDocumentWriter(src.ctx, wri)
+
+	if e := releaseOutput(o); e != nil {
+		return e
+	}
+
+	return err
+}
+
+// writePage writes given page number to wri, with the page bounds as mediabox.
+func (f *Document) writePage(wri *fzDocumentWriter, pageNumber int) error {
+	page := fzLoadPage(f.ctx, f.doc, pageNumber)
+	if page == nil {
+		return ErrLoadPage
+	}
+
+	defer fzDropPage(f.ctx, page)
+
+	device := beginPage(f.ctx, wri, boundPage(f.ctx, page))
+	if device == nil {
+		return ErrWritePage
+	}
+
+	var cookie fzCookie
+	runPage(f.ctx, page, device, fzIdentity, &cookie)
+
+	if err := endPage(f.ctx, wri); err != nil {
+		return err
+	}
+
+	if cookie.Errors > 0 {
+		return ErrRunPageContents
+	}
+
+	return nil
+}
+
+// endPage ends the page begun on wri, returning ErrWritePage if there is none or it is not ended.
+func endPage(ctx *fzContext, wri *fzDocumentWriter) error {
+	if wri.Dev == nil {
+		return ErrWritePage
+	}
+
+	fzEndPage(ctx, wri)
+
+	if wri.Dev != nil {
+		return ErrWritePage
+	}
+
+	return nil
+}
+
+// closeDocumentWriter finishes the document of wri, returning ErrWriteOutput if it is not closed.
+func closeDocumentWriter(ctx *fzContext, wri *fzDocumentWriter) error {
+	if wri.Dev != nil || wri.CloseWriter == nil {
+		return ErrWriteOutput
+	}
+
+	fzCloseDocumentWriter(ctx, wri)
+
+	if wri.CloseWriter != nil {
+		return ErrWriteOutput
+	}
+
+	return nil
+}
+
+// DocumentWriter writes a document composed from the pages of open documents, in the context of one of them.
+type DocumentWriter struct {
+	doc *Document
+	wri *fzDocumentWriter
+	out *outputWriter
+	dev *Device
+	err error
+	mtx sync.Mutex
+}
+
+// Device is the device of a page being written by a DocumentWriter, valid until EndPage.
+type Device struct {
+	w   *DocumentWriter
+	dev *fzDevice
+}
+
+// NewDocumentWriter returns a writer of a document in format to w, with the formats and options of Convert.
+// The writer works in the context of doc, which must stay open until the writer is closed,
+// the pages written may also be composed from the pages of other documents.
+func NewDocumentWriter(doc *Document, w io.Writer, format string, options string) (*DocumentWriter, error) {
+	format, ok := documentFormat(format)
+	if !ok {
+		return nil, ErrDocumentFormat
+	}
+
+	doc.mtx.Lock()
+	defer doc.mtx.Unlock()
+
+	out, o := newOutput(doc.ctx, w)
+	if out == nil {
+		return nil, ErrCreateOutput
+	}
+
+	// The writer owns out from here on, and drops it even if it cannot be created.
+	wri := fzNewDocumentWriterWithOutput(doc.ctx, out, format, options)
+	if wri == nil {
+		releaseOutput(o)
+		return nil, ErrCreateWriter
+	}
+
+	return &DocumentWriter{doc: doc, wri: wri, out: o}, nil
+}
+
+// BeginPage starts a page of the size of mediabox and returns the device to draw it on with Page.Run.
+// It returns nil if the page cannot be started, EndPage and Close then return the error.
+func (w *DocumentWriter) BeginPage(mediabox Rect) *Device {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.wri == nil || w.err != nil {
+		return nil
+	}
+
+	f := w.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	dev := beginPage(f.ctx, w.wri, fzRectOf(mediabox))
+	if dev == nil {
+		w.err = ErrWritePage
+		return nil
+	}
+
+	w.dev = &Device{w: w, dev: dev}
+
+	return w.dev
+}
+
+// EndPage finishes the page started by BeginPage.
+func (w *DocumentWriter) EndPage() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.wri == nil {
+		return ErrWriterClosed
+	}
+
+	if w.err != nil {
+		return w.err
+	}
+
+	if w.dev == nil {
+		return ErrDeviceClosed
+	}
+
+	w.dev.dev, w.dev = nil, nil
+
+	f := w.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	w.err = endPage(f.ctx, w.wri)
+
+	return w.err
+}
+
+// Close finishes the document and releases the writer. The document is incomplete if it returns an error.
+func (w *DocumentWriter) Close() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.wri == nil {
+		return ErrWriterClosed
+	}
+
+	f := w.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	err := w.err
+	if err == nil && w.dev != nil {
+		err = ErrWritePage
+	}
+
+	if err == nil {
+		err = closeDocumentWriter(f.ctx, w.wri)
+	}
+
+	if w.dev != nil {
+		w.dev.dev, w.dev = nil, nil
+	}
+
+	fzDropDocumentWriter(f.ctx, w.wri)
+
+	w.wri = nil
+
+	if e := releaseOutput(w.out); e != nil {
+		return e
+	}
+
+	return err
+}
+
+// SVG returns svg document for given page number.
+func (f *Document) SVG(pageNumber int) (string, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return "", err
+	}
+
+	defer page.Close()
+
+	return page.SVG()
 }
 
 // ToC returns the table of contents (also known as outline).
 func (f *Document) ToC() ([]Outline, error) {
+	return f.toc(false)
+}
+
+// ToCWithLabels returns the table of contents like ToC, with the label of the page of each entry,
+// which loads the pages the entries point to.
+func (f *Document) ToCWithLabels() ([]Outline, error) {
+	return f.toc(true)
+}
+
+// toc returns the table of contents, with page labels if labels is set.
+func (f *Document) toc(labels bool) ([]Outline, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
 	data := make([]Outline, 0)
+	pageLabels := make(map[Location]string)
 
 	outline := fzLoadOutline(f.ctx, f.doc)
 	if outline == nil {
@@ -528,7 +1060,17 @@ func (f *Document) ToC() ([]Outline, error) {
 			res.Title = bytePtrToString((*uint8)(unsafe.Pointer(outline.Title)))
 			res.URI = bytePtrToString((*uint8)(unsafe.Pointer(outline.Uri)))
 			res.Page = int(outline.Page.Page)
-			res.Top = float64(outline.Y)
+			res.Location = goLocation(outline.Page)
+			if labels && res.Location.Page >= 0 {
+				label, ok := pageLabels[res.Location]
+				if !ok {
+					label, _ = f.pageLabel(outline.Page)
+					pageLabels[res.Location] = label
+				}
+				res.Label = label
+			}
+			res.Position = Point{X: float64(outline.X), Y: float64(outline.Y)}
+			res.Top = res.Position.Y
 			data = append(data, res)
 
 			if outline.Down != nil {
@@ -543,333 +1085,1863 @@ func (f *Document) ToC() ([]Outline, error) {
 	return data, nil
 }
 
-// Metadata returns the map with standard metadata.
-func (f *Document) Metadata() map[string]string {
-	data := make(map[string]string)
+// Metadata returns the map with standard metadata.
+func (f *Document) Metadata() map[string]string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	data := make(map[string]string)
+
+	data["format"] = f.lookupMetadata("format")
+	data["encryption"] = f.lookupMetadata("encryption")
+	data["title"] = f.lookupMetadata("info:Title")
+	data["author"] = f.lookupMetadata("info:Author")
+	data["subject"] = f.lookupMetadata("info:Subject")
+	data["keywords"] = f.lookupMetadata("info:Keywords")
+	data["creator"] = f.lookupMetadata("info:Creator")
+	data["producer"] = f.lookupMetadata("info:Producer")
+	data["creationDate"] = f.lookupMetadata("info:CreationDate")
+	data["modDate"] = f.lookupMetadata("info:ModDate")
+
+	return data
+}
+
+// Info returns the document information with typed encryption and permission details.
+func (f *Document) Info() Info {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	info := Info{
+		Format:       f.lookupMetadata("format"),
+		Encryption:   f.lookupMetadata("encryption"),
+		Title:        f.lookupMetadata("info:Title"),
+		Author:       f.lookupMetadata("info:Author"),
+		Subject:      f.lookupMetadata("info:Subject"),
+		Keywords:     f.lookupMetadata("info:Keywords"),
+		Creator:      f.lookupMetadata("info:Creator"),
+		Producer:     f.lookupMetadata("info:Producer"),
+		CreationDate: f.lookupMetadata("info:CreationDate"),
+		ModDate:      f.lookupMetadata("info:ModDate"),
+	}
+
+	info.Encrypted = info.Encryption != "" && info.Encryption != "None"
+
+	for _, perm := range permissions {
+		if f.hasPermission(perm.flag) {
+			info.Permissions |= perm.flag
+		}
+	}
+
+	return info
+}
+
+// lookupMetadata returns the metadata value for key up to the first NUL, or an empty string if it is not found.
+func (f *Document) lookupMetadata(key string) string {
+	buf := make([]byte, 256)
+	if fzLookupMetadata(f.ctx, f.doc, key, unsafe.SliceData(buf), len(buf)) < 0 {
+		return ""
+	}
+
+	return bytePtrToString(unsafe.SliceData(buf))
+}
+
+// HasPermission reports whether the document grants all the permissions in p.
+func (f *Document) HasPermission(p Permission) bool {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return f.hasPermission(p)
+}
+
+func (f *Document) hasPermission(p Permission) bool {
+	for _, perm := range permissions {
+		if p&perm.flag != 0 && fzHasPermission(f.ctx, f.doc, perm.code) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Bound gives the Bounds of a given Page in the document.
+func (f *Document) Bound(pageNumber int) (image.Rectangle, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+
+	defer page.Close()
+
+	return page.Bound()
+}
+
+// PageBox returns the box of given page number in page points.
+func (f *Document) PageBox(pageNumber int, box BoxType) (Rect, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return Rect{}, err
+	}
+
+	defer page.Close()
+
+	return page.Box(box)
+}
+
+// IsReflowable reports whether the document layout depends on the page and font size, as for EPUB, MOBI, FB2 and HTML.
+func (f *Document) IsReflowable() bool {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return fzIsDocumentReflowable(f.ctx, f.doc) != 0
+}
+
+// Layout lays out a reflowable document in pages of w x h points with a font size of em points, which changes the page count.
+// Pages loaded before must be loaded again, MakeBookmark keeps a reading position across layouts.
+// It returns ErrLayout for fixed layout documents and sizes that are not positive.
+func (f *Document) Layout(w, h, em float64) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if w <= 0 || h <= 0 || em <= 0 || fzIsDocumentReflowable(f.ctx, f.doc) == 0 {
+		return ErrLayout
+	}
+
+	for _, l := range f.lists.clear() {
+		fzDropDisplayList(f.ctx, (*fzDisplayList)(l))
+	}
+
+	fzLayoutDocument(f.ctx, f.doc, float32(w), float32(h), float32(em))
+
+	return nil
+}
+
+// SetUserCSS sets a stylesheet applied over the styles of reflowable documents, it takes effect on the next Layout.
+func (f *Document) SetUserCSS(css string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	fzSetUserCss(f.ctx, css)
+}
+
+// MakeBookmark returns a bookmark for given page number, to find the same position with LookupBookmark after Layout.
+func (f *Document) MakeBookmark(pageNumber int) (Bookmark, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if pageNumber >= f.NumPage() {
+		return 0, ErrPageMissing
+	}
+
+	return Bookmark(makeBookmark(f.ctx, f.doc, pageNumber)), nil
+}
+
+// LookupBookmark returns the page number of a bookmark in the current layout.
+func (f *Document) LookupBookmark(mark Bookmark) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	loc := lookupBookmark(f.ctx, f.doc, int64(mark))
+	if loc.Page < 0 {
+		return 0, ErrBookmark
+	}
+
+	pageNumber := pageNumberFromLocation(f.ctx, f.doc, loc)
+	if pageNumber < 0 {
+		return 0, ErrBookmark
+	}
+
+	return pageNumber, nil
+}
+
+// Close closes the underlying fitz document.
+func (f *Document) Close() error {
+	if f.stream != nil {
+		fzDropStream(f.ctx, f.stream)
+	}
+
+	for _, l := range f.lists.clear() {
+		fzDropDisplayList(f.ctx, (*fzDisplayList)(l))
+	}
+
+	fzDropDocument(f.ctx, f.doc)
+	fzDropContext(f.ctx)
+
+	f.data = nil
+
+	return nil
+}
+
+var (
+	libmupdf uintptr
+
+	fzNewSvgDevice             func(ctx *fzContext, out *fzOutput, pageWidth, pageHeight float32, textFormat, reuseImages int) *fzDevice
+	fzNewContextImp            func(alloc *fzAllocContext, locks *fzLocksContext, maxStore uint64, version string) *fzContext
+	fzDropContext              func(ctx *fzContext)
+	fzOpenDocument             func(ctx *fzContext, filename string) *fzDocument
+	fzOpenDocumentWithStream   func(ctx *fzContext, magic string, stream *fzStream) *fzDocument
+	fzOpenDocumentWithBuffer   func(ctx *fzContext, magic string, buf *fzBuffer) *fzDocument
+	fzOpenMemory               func(ctx *fzContext, data *uint8, len uint64) *fzStream
+	fzDropStream               func(ctx *fzContext, stm *fzStream)
+	fzRegisterDocumentHandlers func(ctx *fzContext)
+	fzNeedsPassword            func(ctx *fzContext, doc *fzDocument) int
+	fzAuthenticatePassword     func(ctx *fzContext, doc *fzDocument, password string) int
+	fzDropDocument             func(ctx *fzContext, doc *fzDocument)
+	fzCountPages               func(ctx *fzContext, doc *fzDocument) int
+	fzLoadPage                 func(ctx *fzContext, doc *fzDocument, number int) *fzPage
+	fzDropPage                 func(ctx *fzContext, page *fzPage)
+	fzNewPixmap                func(ctx *fzContext, colorspace *fzColorspace, w, h int, seps *fzSeparations, alpha int) *fzPixmap
+	fzDropPixmap               func(ctx *fzContext, pix *fzPixmap)
+	fzPixmapSamples            func(ctx *fzContext, pix *fzPixmap) *uint8
+	fzClearPixmapWithValue     func(ctx *fzContext, pix *fzPixmap, value int)
+	fzEnableDeviceHints        func(ctx *fzContext, dev *fzDevice, hints int)
+	fzDropDevice               func(ctx *fzContext, dev *fzDevice)
+	fzCloseDevice              func(ctx *fzContext, dev *fzDevice)
+	fzDeviceRgb                func(ctx *fzContext) *fzColorspace
+	fzDeviceGray               func(ctx *fzContext) *fzColorspace
+	fzDeviceBgr                func(ctx *fzContext) *fzColorspace
+	fzDeviceCmyk               func(ctx *fzContext) *fzColorspace
+	fzClearPixmap              func(ctx *fzContext, pix *fzPixmap)
+	fzTextAaLevel              func(ctx *fzContext) int
+	fzGraphicsAaLevel          func(ctx *fzContext) int
+	fzSetTextAaLevel           func(ctx *fzContext, bits int)
+	fzSetGraphicsAaLevel       func(ctx *fzContext, bits int)
+	fzNewBuffer                func(ctx *fzContext, size uint64) *fzBuffer
+	fzDropBuffer               func(ctx *fzContext, buf *fzBuffer)
+	fzBufferStorage            func(ctx *fzContext, buf *fzBuffer, data **uint8) uint64
+	fzStringFromBuffer         func(ctx *fzContext, buf *fzBuffer) *uint8
+	fzNewBufferFromCopiedData  func(ctx *fzContext, data *uint8, size uint64) *fzBuffer
+	fzLoadLinks                func(ctx *fzContext, page *fzPage) *fzLink
+	fzDropLink                 func(ctx *fzContext, link *fzLink)
+	fzDropStextPage            func(ctx *fzContext, page *fzStextPage)
+	fzNewStextDevice           func(ctx *fzContext, page *fzStextPage, options *fzStextOptions) *fzDevice
+	fzNewBufferFromStextPage   func(ctx *fzContext, page *fzStextPage) *fzBuffer
+	fzLookupMetadata           func(ctx *fzContext, doc *fzDocument, key string, buf *uint8, size int) int
+	fzHasPermission            func(ctx *fzContext, doc *fzDocument, p int) int
+	fzLoadOutline              func(ctx *fzContext, doc *fzDocument) *fzOutline
+	fzDropOutline              func(ctx *fzContext, outline *fzOutline)
+	fzNewOutputWithBuffer      func(ctx *fzContext, buf *fzBuffer) *fzOutput
+	fzDropOutput               func(ctx *fzContext, out *fzOutput)
+	fzCloseOutput              func(ctx *fzContext, out *fzOutput)
+	fzPrintStextPageAsHTML     func(ctx *fzContext, out *fzOutput, page *fzStextPage, id int)
+	fzPrintStextHeaderAsHTML   func(ctx *fzContext, out *fzOutput)
+	fzPrintStextTrailerAsHTML  func(ctx *fzContext, out *fzOutput)
+	fzPrintStextPageAsXHTML    func(ctx *fzContext, out *fzOutput, page *fzStextPage, id int)
+	fzPrintStextHeaderAsXHTML  func(ctx *fzContext, out *fzOutput)
+	fzPrintStextTrailerAsXHTML func(ctx *fzContext, out *fzOutput)
+	fzPrintStextPageAsXML      func(ctx *fzContext, out *fzOutput, page *fzStextPage, id int)
+	fzPrintStextPageAsJSON     func(ctx *fzContext, out *fzOutput, page *fzStextPage, scale float32)
+	fzPrintStextPageAsText     func(ctx *fzContext, out *fzOutput, page *fzStextPage)
+	fzNewOutput                func(ctx *fzContext, bufsiz int, state uintptr, write, close, drop uintptr) *fzOutput
+	fzSetWarningCallback       func(ctx *fzContext, cb uintptr, user *byte)
+	fzFontName                 func(ctx *fzContext, font *fzFont) *uint8
+	fzParseStextOptions        func(ctx *fzContext, opts *fzStextOptions, str string) *fzStextOptions
+	fzMatchStextPage           func(ctx *fzContext, text *fzStextPage, needle string, hitMark *int32, hitBbox *fzQuad, hitMax, options int) int
+	fzFree                     func(ctx *fzContext, p *uint8)
+	fzIsExternalLink           func(ctx *fzContext, uri *uint8) int
+	fzCompressedImageBuffer    func(ctx *fzContext, image *fzImage) *fzCompressedBuffer
+	fzImageTypeName            func(typ int) *uint8
+	fzColorspaceName           func(ctx *fzContext, cs *fzColorspace) *uint8
+	fzIsDocumentReflowable     func(ctx *fzContext, doc *fzDocument) int
+	fzLayoutDocument           func(ctx *fzContext, doc *fzDocument, w, h, em float32)
+	fzSetUserCss               func(ctx *fzContext, text string)
+	fzCountChapters            func(ctx *fzContext, doc *fzDocument) int
+	fzCountChapterPages        func(ctx *fzContext, doc *fzDocument, chapter int) int
+	fzLoadChapterPage          func(ctx *fzContext, doc *fzDocument, chapter, page int) *fzPage
+	fzPageLabel                func(ctx *fzContext, page *fzPage, buf *uint8, size int) *uint8
+	fzNewListDevice            func(ctx *fzContext, list *fzDisplayList) *fzDevice
+	fzKeepDisplayList          func(ctx *fzContext, list *fzDisplayList) *fzDisplayList
+	fzDropDisplayList          func(ctx *fzContext, list *fzDisplayList)
+	fzNewPngBandWriter         func(ctx *fzContext, out *fzOutput) *fzBandWriter
+	fzNewPamBandWriter         func(ctx *fzContext, out *fzOutput) *fzBandWriter
+	fzNewPnmBandWriter         func(ctx *fzContext, out *fzOutput) *fzBandWriter
+	fzNewPsdBandWriter         func(ctx *fzContext, out *fzOutput) *fzBandWriter
+	fzWriteHeader              func(ctx *fzContext, writer *fzBandWriter, w, h, n, alpha, xres, yres, pagenum int, cs *fzColorspace, seps *fzSeparations)
+	fzWriteBand                func(ctx *fzContext, writer *fzBandWriter, stride, bandHeight int, samples *uint8)
+	fzCloseBandWriter          func(ctx *fzContext, writer *fzBandWriter)
+	fzDropBandWriter           func(ctx *fzContext, writer *fzBandWriter)
+
+	fzNewDocumentWriterWithOutput func(ctx *fzContext, out *fzOutput, format, options string) *fzDocumentWriter
+	fzEndPage                     func(ctx *fzContext, wri *fzDocumentWriter)
+	fzCloseDocumentWriter         func(ctx *fzContext, wri *fzDocumentWriter)
+	fzDropDocumentWriter          func(ctx *fzContext, wri *fzDocumentWriter)
+
+	silentWarning uintptr
+	writeOutput   uintptr
+)
+
+// outputs maps the state of each fz_output created by newOutput to its writer.
+var outputs = struct {
+	sync.Mutex
+	m    map[uintptr]*outputWriter
+	next uintptr
+}{m: make(map[uintptr]*outputWriter)}
+
+// watchCookie aborts the cookie when ctx is done and reports its progress to fn, until the returned stop func is called.
+func watchCookie(ctx context.Context, fn ProgressFunc, cookie *fzCookie) func() {
+	return watch(ctx, fn, func() {
+		atomic.StoreInt32(&cookie.Abort, 1)
+	}, func() (int, int) {
+		return int(atomic.LoadInt32(&cookie.Progress)), int(atomic.LoadUint64(&cookie.Max))
+	})
+}
+
+// cookieErr returns the error of a run with cookie: err, the error of ctx if it aborted, or ErrPartialRender.
+func cookieErr(ctx context.Context, cookie *fzCookie, err error) error {
+	switch {
+	case err != nil:
+		return err
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case atomic.LoadInt32(&cookie.Errors) > 0:
+		return ErrPartialRender
+	}
+
+	return nil
+}
+
+// newOutput returns an fz_output that writes to w, it must be released with dropOutput.
+func newOutput(ctx *fzContext, w io.Writer) (*fzOutput, *outputWriter) {
+	o := &outputWriter{w: w}
+
+	outputs.Lock()
+	outputs.next++
+	o.handle = outputs.next
+	outputs.m[o.handle] = o
+	outputs.Unlock()
+
+	out := fzNewOutput(ctx, 8192, o.handle, writeOutput, 0, 0)
+	if out == nil {
+		outputs.Lock()
+		delete(outputs.m, o.handle)
+		outputs.Unlock()
+
+		return nil, nil
+	}
+
+	return out, o
+}
+
+// dropOutput releases the fz_output and returns the first error from its writer.
+func dropOutput(ctx *fzContext, out *fzOutput, o *outputWriter) error {
+	fzDropOutput(ctx, out)
+
+	return releaseOutput(o)
+}
+
+// releaseOutput releases the writer of an fz_output dropped by its owner and returns its first error.
+func releaseOutput(o *outputWriter) error {
+	outputs.Lock()
+	delete(outputs.m, o.handle)
+	outputs.Unlock()
+
+	return o.err
+}
+
+// silenceWarnings installs a no-op warning callback, suppressing MuPDF's stderr warnings.
+func silenceWarnings(ctx *fzContext) {
+	fzSetWarningCallback(ctx, silentWarning, nil)
+}
+
+func init() {
+	libmupdf = loadLibrary()
+
+	if os.Getenv("FZ_VERSION") != "" {
+		FzVersion = os.Getenv("FZ_VERSION")
+	}
+
+	registerStructFuncs(libmupdf)
+
+	purego.RegisterLibFunc(&fzSetWarningCallback, libmupdf, "fz_set_warning_callback")
+	silentWarning = purego.NewCallback(func(user *byte, message *byte) {})
+	writeOutput = purego.NewCallback(func(ctx *fzContext, state uintptr, data *byte, n uintptr) {
+		outputs.Lock()
+		o := outputs.m[state]
+		outputs.Unlock()
+
+		o.write(unsafe.Slice(data, n))
+	})
+
+	purego.RegisterLibFunc(&fzNewSvgDevice, libmupdf, "fz_new_svg_device")
+	purego.RegisterLibFunc(&fzNewContextImp, libmupdf, "fz_new_context_imp")
+	purego.RegisterLibFunc(&fzDropContext, libmupdf, "fz_drop_context")
+	purego.RegisterLibFunc(&fzOpenDocument, libmupdf, "fz_open_document")
+	purego.RegisterLibFunc(&fzOpenDocumentWithStream, libmupdf, "fz_open_document_with_stream")
+	purego.RegisterLibFunc(&fzOpenDocumentWithBuffer, libmupdf, "fz_open_document_with_buffer")
+	purego.RegisterLibFunc(&fzOpenMemory, libmupdf, "fz_open_memory")
+	purego.RegisterLibFunc(&fzDropStream, libmupdf, "fz_drop_stream")
+	purego.RegisterLibFunc(&fzRegisterDocumentHandlers, libmupdf, "fz_register_document_handlers")
+	purego.RegisterLibFunc(&fzNeedsPassword, libmupdf, "fz_needs_password")
+	purego.RegisterLibFunc(&fzAuthenticatePassword, libmupdf, "fz_authenticate_password")
+	purego.RegisterLibFunc(&fzDropDocument, libmupdf, "fz_drop_document")
+	purego.RegisterLibFunc(&fzCountPages, libmupdf, "fz_count_pages")
+	purego.RegisterLibFunc(&fzLoadPage, libmupdf, "fz_load_page")
+	purego.RegisterLibFunc(&fzDropPage, libmupdf, "fz_drop_page")
+	purego.RegisterLibFunc(&fzNewPixmap, libmupdf, "fz_new_pixmap")
+	purego.RegisterLibFunc(&fzDropPixmap, libmupdf, "fz_drop_pixmap")
+	purego.RegisterLibFunc(&fzPixmapSamples, libmupdf, "fz_pixmap_samples")
+	purego.RegisterLibFunc(&fzClearPixmapWithValue, libmupdf, "fz_clear_pixmap_with_value")
+	purego.RegisterLibFunc(&fzEnableDeviceHints, libmupdf, "fz_enable_device_hints")
+	purego.RegisterLibFunc(&fzDropDevice, libmupdf, "fz_drop_device")
+	purego.RegisterLibFunc(&fzCloseDevice, libmupdf, "fz_close_device")
+	purego.RegisterLibFunc(&fzDeviceRgb, libmupdf, "fz_device_rgb")
+	purego.RegisterLibFunc(&fzDeviceGray, libmupdf, "fz_device_gray")
+	purego.RegisterLibFunc(&fzDeviceBgr, libmupdf, "fz_device_bgr")
+	purego.RegisterLibFunc(&fzDeviceCmyk, libmupdf, "fz_device_cmyk")
+	purego.RegisterLibFunc(&fzClearPixmap, libmupdf, "fz_clear_pixmap")
+	purego.RegisterLibFunc(&fzTextAaLevel, libmupdf, "fz_text_aa_level")
+	purego.RegisterLibFunc(&fzGraphicsAaLevel, libmupdf, "fz_graphics_aa_level")
+	purego.RegisterLibFunc(&fzSetTextAaLevel, libmupdf, "fz_set_text_aa_level")
+	purego.RegisterLibFunc(&fzSetGraphicsAaLevel, libmupdf, "fz_set_graphics_aa_level")
+	purego.RegisterLibFunc(&fzNewBuffer, libmupdf, "fz_new_buffer")
+	purego.RegisterLibFunc(&fzDropBuffer, libmupdf, "fz_drop_buffer")
+	purego.RegisterLibFunc(&fzBufferStorage, libmupdf, "fz_buffer_storage")
+	purego.RegisterLibFunc(&fzStringFromBuffer, libmupdf, "fz_string_from_buffer")
+	purego.RegisterLibFunc(&fzNewBufferFromCopiedData, libmupdf, "fz_new_buffer_from_copied_data")
+	purego.RegisterLibFunc(&fzLoadLinks, libmupdf, "fz_load_links")
+	purego.RegisterLibFunc(&fzDropLink, libmupdf, "fz_drop_link")
+	purego.RegisterLibFunc(&fzDropStextPage, libmupdf, "fz_drop_stext_page")
+	purego.RegisterLibFunc(&fzNewStextDevice, libmupdf, "fz_new_stext_device")
+	purego.RegisterLibFunc(&fzNewBufferFromStextPage, libmupdf, "fz_new_buffer_from_stext_page")
+	purego.RegisterLibFunc(&fzLookupMetadata, libmupdf, "fz_lookup_metadata")
+	purego.RegisterLibFunc(&fzHasPermission, libmupdf, "fz_has_permission")
+	purego.RegisterLibFunc(&fzLoadOutline, libmupdf, "fz_load_outline")
+	purego.RegisterLibFunc(&fzDropOutline, libmupdf, "fz_drop_outline")
+	purego.RegisterLibFunc(&fzNewOutputWithBuffer, libmupdf, "fz_new_output_with_buffer")
+	purego.RegisterLibFunc(&fzDropOutput, libmupdf, "fz_drop_output")
+	purego.RegisterLibFunc(&fzCloseOutput, libmupdf, "fz_close_output")
+	purego.RegisterLibFunc(&fzPrintStextPageAsHTML, libmupdf, "fz_print_stext_page_as_html")
+	purego.RegisterLibFunc(&fzPrintStextHeaderAsHTML, libmupdf, "fz_print_stext_header_as_html")
+	purego.RegisterLibFunc(&fzPrintStextTrailerAsHTML, libmupdf, "fz_print_stext_trailer_as_html")
+	purego.RegisterLibFunc(&fzPrintStextPageAsXHTML, libmupdf, "fz_print_stext_page_as_xhtml")
+	purego.RegisterLibFunc(&fzPrintStextHeaderAsXHTML, libmupdf, "fz_print_stext_header_as_xhtml")
+	purego.RegisterLibFunc(&fzPrintStextTrailerAsXHTML, libmupdf, "fz_print_stext_trailer_as_xhtml")
+	purego.RegisterLibFunc(&fzPrintStextPageAsXML, libmupdf, "fz_print_stext_page_as_xml")
+	purego.RegisterLibFunc(&fzPrintStextPageAsJSON, libmupdf, "fz_print_stext_page_as_json")
+	purego.RegisterLibFunc(&fzPrintStextPageAsText, libmupdf, "fz_print_stext_page_as_text")
+	purego.RegisterLibFunc(&fzNewOutput, libmupdf, "fz_new_output")
+	purego.RegisterLibFunc(&fzFontName, libmupdf, "fz_font_name")
+	purego.RegisterLibFunc(&fzParseStextOptions, libmupdf, "fz_parse_stext_options")
+	purego.RegisterLibFunc(&fzMatchStextPage, libmupdf, "fz_match_stext_page")
+	purego.RegisterLibFunc(&fzFree, libmupdf, "fz_free")
+	purego.RegisterLibFunc(&fzIsExternalLink, libmupdf, "fz_is_external_link")
+	purego.RegisterLibFunc(&fzCompressedImageBuffer, libmupdf, "fz_compressed_image_buffer")
+	purego.RegisterLibFunc(&fzImageTypeName, libmupdf, "fz_image_type_name")
+	purego.RegisterLibFunc(&fzColorspaceName, libmupdf, "fz_colorspace_name")
+	purego.RegisterLibFunc(&fzIsDocumentReflowable, libmupdf, "fz_is_document_reflowable")
+	purego.RegisterLibFunc(&fzLayoutDocument, libmupdf, "fz_layout_document")
+	purego.RegisterLibFunc(&fzSetUserCss, libmupdf, "fz_set_user_css")
+	purego.RegisterLibFunc(&fzCountChapters, libmupdf, "fz_count_chapters")
+	purego.RegisterLibFunc(&fzCountChapterPages, libmupdf, "fz_count_chapter_pages")
+	purego.RegisterLibFunc(&fzLoadChapterPage, libmupdf, "fz_load_chapter_page")
+	purego.RegisterLibFunc(&fzPageLabel, libmupdf, "fz_page_label")
+	purego.RegisterLibFunc(&fzNewListDevice, libmupdf, "fz_new_list_device")
+	purego.RegisterLibFunc(&fzKeepDisplayList, libmupdf, "fz_keep_display_list")
+	purego.RegisterLibFunc(&fzDropDisplayList, libmupdf, "fz_drop_display_list")
+	purego.RegisterLibFunc(&fzNewPngBandWriter, libmupdf, "fz_new_png_band_writer")
+	purego.RegisterLibFunc(&fzNewPamBandWriter, libmupdf, "fz_new_pam_band_writer")
+	purego.RegisterLibFunc(&fzNewPnmBandWriter, libmupdf, "fz_new_pnm_band_writer")
+	purego.RegisterLibFunc(&fzNewPsdBandWriter, libmupdf, "fz_new_psd_band_writer")
+	purego.RegisterLibFunc(&fzWriteHeader, libmupdf, "fz_write_header")
+	purego.RegisterLibFunc(&fzWriteBand, libmupdf, "fz_write_band")
+	purego.RegisterLibFunc(&fzCloseBandWriter, libmupdf, "fz_close_band_writer")
+	purego.RegisterLibFunc(&fzDropBandWriter, libmupdf, "fz_drop_band_writer")
+	purego.RegisterLibFunc(&fzNewDocumentWriterWithOutput, libmupdf, "fz_new_document_writer_with_output")
+	purego.RegisterLibFunc(&fzEndPage, libmupdf, "fz_end_page")
+	purego.RegisterLibFunc(&fzCloseDocumentWriter, libmupdf, "fz_close_document_writer")
+	purego.RegisterLibFunc(&fzDropDocumentWriter, libmupdf, "fz_drop_document_writer")
+
+	ver := version()
+	if ver != "" {
+		FzVersion = ver
+	}
+}
+
+// libVersionRe matches the library version in fz_new_context_imp's mismatch message.
+var libVersionRe = regexp.MustCompile(`library \(([0-9]+\.[0-9]+\.[0-9]+)\)`)
+
+// version finds the libmupdf build version fz_new_context_imp needs: FzVersion, then the mismatch message, then a scan.
+func version() string {
+	var ok bool
+	out := captureStderr(func() { ok = probeVersion(FzVersion) })
+	if ok {
+		return FzVersion
+	}
+
+	if m := libVersionRe.FindStringSubmatch(out); m != nil && m[1] != FzVersion {
+		captureStderr(func() { ok = probeVersion(m[1]) })
+		if ok {
+			return m[1]
+		}
+	}
+
+	var found string
+	captureStderr(func() { found = scanVersion() })
+
+	return found
+}
+
+// probeVersion reports whether ver is the libmupdf build version.
+func probeVersion(ver string) bool {
+	ctx := fzNewContextImp(nil, nil, uint64(MaxStore), ver)
+	if ctx == nil {
+		return false
+	}
+
+	fzDropContext(ctx)
+
+	return true
+}
+
+// scanVersion brute-forces minor/patch combinations around FzVersion as a fallback.
+func scanVersion() string {
+	s := strings.Split(FzVersion, ".")
+	if len(s) != 3 {
+		return ""
+	}
+
+	minor, err := strconv.Atoi(s[1])
+	if err != nil {
+		return ""
+	}
+
+	for m := minor + 2; m >= 0; m-- {
+		for p := 12; p >= 0; p-- {
+			ver := s[0] + "." + strconv.Itoa(m) + "." + strconv.Itoa(p)
+			if ver == FzVersion {
+				continue
+			}
+
+			if probeVersion(ver) {
+				return ver
+			}
+		}
+	}
+
+	return ""
+}
+
+// scale ports fz_scale.
+func scale(sx, sy float32) fzMatrix {
+	return fzMatrix{A: sx, D: sy}
+}
+
+// transformRect ports fz_transform_rect.
+func transformRect(r fzRect, m fzMatrix) fzRect {
+	return fzRectOf(goRect(r).Transform(goMatrix(m)))
+}
+
+// roundRect ports fz_round_rect.
+func roundRect(r fzRect) fzIRect {
+	b := goRect(r).Round()
+
+	return fzIRect{X0: int32(b.Min.X), Y0: int32(b.Min.Y), X1: int32(b.Max.X), Y1: int32(b.Max.Y)}
+}
+
+// Page represents a page loaded from a fitz document, it must be released with Close before the document is closed.
+type Page struct {
+	doc    *Document
+	page   *fzPage
+	list   *fzDisplayList
+	number int
+}
+
+// LoadPage loads the given page number, keeping it parsed between calls.
+func (f *Document) LoadPage(pageNumber int) (*Page, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if pageNumber >= f.NumPage() {
+		return nil, ErrPageMissing
+	}
+
+	page := fzLoadPage(f.ctx, f.doc, pageNumber)
+	if page == nil {
+		return nil, ErrLoadPage
+	}
+
+	return &Page{doc: f, page: page, number: pageNumber}, nil
+}
+
+// CountChapters returns the number of chapters in the document.
+func (f *Document) CountChapters() int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return fzCountChapters(f.ctx, f.doc)
+}
+
+// CountChapterPages returns the number of pages in the chapter.
+func (f *Document) CountChapterPages(chapter int) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if chapter < 0 || chapter >= fzCountChapters(f.ctx, f.doc) {
+		return 0, ErrPageMissing
+	}
+
+	return fzCountChapterPages(f.ctx, f.doc, chapter), nil
+}
+
+// LoadChapterPage loads the page at loc, without counting the pages of the chapters before it.
+func (f *Document) LoadChapterPage(loc Location) (*Page, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if !f.validLocation(loc) {
+		return nil, ErrPageMissing
+	}
+
+	page := fzLoadChapterPage(f.ctx, f.doc, loc.Chapter, loc.Page)
+	if page == nil {
+		return nil, ErrLoadPage
+	}
+
+	number := pageNumberFromLocation(f.ctx, f.doc, fzLocationOf(loc))
+
+	return &Page{doc: f, page: page, number: number}, nil
+}
+
+// NextLocation returns the location of the page after loc, or loc if it is the last page.
+func (f *Document) NextLocation(loc Location) (Location, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if !f.validLocation(loc) {
+		return loc, ErrPageMissing
+	}
+
+	return goLocation(nextPage(f.ctx, f.doc, fzLocationOf(loc))), nil
+}
+
+// PreviousLocation returns the location of the page before loc, or loc if it is the first page.
+func (f *Document) PreviousLocation(loc Location) (Location, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if !f.validLocation(loc) {
+		return loc, ErrPageMissing
+	}
+
+	return goLocation(previousPage(f.ctx, f.doc, fzLocationOf(loc))), nil
+}
+
+// LocationFromPage returns the location of given page number.
+func (f *Document) LocationFromPage(pageNumber int) (Location, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if pageNumber < 0 || pageNumber >= f.NumPage() {
+		return Location{}, ErrPageMissing
+	}
+
+	return goLocation(locationFromPageNumber(f.ctx, f.doc, pageNumber)), nil
+}
+
+// PageFromLocation returns the page number at loc.
+func (f *Document) PageFromLocation(loc Location) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if !f.validLocation(loc) {
+		return 0, ErrPageMissing
+	}
+
+	return pageNumberFromLocation(f.ctx, f.doc, fzLocationOf(loc)), nil
+}
+
+// PageLabel returns the label of given page number, e.g. "xii", or the page number counting from 1 if the document has no page labels.
+func (f *Document) PageLabel(pageNumber int) (string, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return "", err
+	}
+
+	defer page.Close()
+
+	return page.Label()
+}
+
+// PageByLabel returns the number of the first page with the label.
+func (f *Document) PageByLabel(label string) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	number := 0
+	for chapter := 0; chapter < fzCountChapters(f.ctx, f.doc); chapter++ {
+		pages := fzCountChapterPages(f.ctx, f.doc, chapter)
+		for page := 0; page < pages; page++ {
+			if l, ok := f.pageLabel(fzLocation{Chapter: int32(chapter), Page: int32(page)}); ok && l == label {
+				return number, nil
+			}
+			number++
+		}
+	}
+
+	return 0, ErrPageMissing
+}
+
+// pageLabel returns the label of the page at loc.
+func (f *Document) pageLabel(loc fzLocation) (string, bool) {
+	page := fzLoadChapterPage(f.ctx, f.doc, int(loc.Chapter), int(loc.Page))
+	if page == nil {
+		return "", false
+	}
+
+	defer fzDropPage(f.ctx, page)
+
+	var buf [pageLabelSize]uint8
+
+	return bytePtrToString(fzPageLabel(f.ctx, page, &buf[0], len(buf))), true
+}
+
+// validLocation reports whether loc addresses a page of the document.
+func (f *Document) validLocation(loc Location) bool {
+	if loc.Chapter < 0 || loc.Chapter >= fzCountChapters(f.ctx, f.doc) || loc.Page < 0 {
+		return false
+	}
+
+	return loc.Page < fzCountChapterPages(f.ctx, f.doc, loc.Chapter)
+}
+
+// Number returns the page number.
+func (p *Page) Number() int {
+	return p.number
+}
+
+// Bound gives the Bounds of the page.
+func (p *Page) Bound() (image.Rectangle, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return image.Rectangle{}, ErrPageClosed
+	}
+
+	var bounds fzRect
+	bounds = boundPage(f.ctx, p.page)
+
+	return image.Rect(int(bounds.X0), int(bounds.Y0), int(bounds.X1), int(bounds.Y1)), nil
+}
+
+// Box returns the box of the page in page points, e.g. the trim box, without rounding.
+func (p *Page) Box(box BoxType) (Rect, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return Rect{}, ErrPageClosed
+	}
+
+	return goRect(boundPageBox(f.ctx, p.page, box.fz())), nil
+}
+
+// Render returns image of the page at the given DPI.
+func (p *Page) Render(dpi float64) (*image.RGBA, error) {
+	img, err := p.RenderWithOptions(RenderOptions{DPI: dpi})
+	if err != nil {
+		return nil, err
+	}
+
+	return img.(*image.RGBA), nil
+}
+
+// RenderWithOptions returns image of the page rendered with the given options.
+func (p *Page) RenderWithOptions(opts RenderOptions) (image.Image, error) {
+	return p.render(opts, nil)
+}
+
+// RenderContext returns image of the page at the given DPI, aborting when ctx is done and reporting to progress if not nil.
+// It returns the image along with ErrPartialRender if errors were encountered while rendering.
+func (p *Page) RenderContext(ctx context.Context, dpi float64, progress ProgressFunc) (*image.RGBA, error) {
+	var cookie fzCookie
+
+	stop := watchCookie(ctx, progress, &cookie)
+	img, err := p.render(RenderOptions{DPI: dpi}, &cookie)
+	stop()
+
+	err = cookieErr(ctx, &cookie, err)
+	if img == nil || ctx.Err() != nil {
+		return nil, err
+	}
+
+	return img.(*image.RGBA), err
+}
+
+// render renders the page with the given options, cookie may be nil.
+func (p *Page) render(opts RenderOptions, cookie *fzCookie) (image.Image, error) {
+	f := p.doc
+
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return nil, ErrPageClosed
+	}
+
+	var bounds fzRect
+	bounds = boundPage(f.ctx, p.page)
+
+	if opts.Box != BoxCrop {
+		bounds = boundPageBox(f.ctx, p.page, opts.Box.fz())
+	}
+
+	var ctm fzMatrix
+	ctm = fzMatrixOf(opts.matrix())
+
+	if opts.rotated() {
+		r := transformRect(bounds, ctm)
+		ctm.E -= r.X0
+		ctm.F -= r.Y0
+	}
+
+	if opts.Clip != (Rect{}) {
+		bounds = fzRectOf(goRect(bounds).Intersect(opts.Clip))
+	}
+
+	var bbox fzIRect
+	bounds = transformRect(bounds, ctm)
+	bbox = roundRect(bounds)
+
+	colorspace, alpha := fzDeviceRgb(f.ctx), 1
+	switch opts.Colorspace {
+	case ColorspaceGray:
+		colorspace, alpha = fzDeviceGray(f.ctx), 0
+		if opts.Transparent {
+			alpha = 1
+		}
+	case ColorspaceBGR:
+		colorspace = fzDeviceBgr(f.ctx)
+	case ColorspaceCMYK:
+		colorspace, alpha = fzDeviceCmyk(f.ctx), 0
+	}
+
+	pixmap := fzNewPixmap(f.ctx, colorspace, int(bbox.X1-bbox.X0), int(bbox.Y1-bbox.Y0), nil, alpha)
+	if pixmap == nil {
+		return nil, ErrCreatePixmap
+	}
+
+	pixmap.X, pixmap.Y = bbox.X0, bbox.Y0
+
+	if opts.Transparent && alpha == 1 {
+		fzClearPixmap(f.ctx, pixmap)
+	} else {
+		fzClearPixmapWithValue(f.ctx, pixmap, 0xff)
+	}
+	defer fzDropPixmap(f.ctx, pixmap)
+
+	defer f.setAntiAlias(opts)()
+
+	device := newDrawDevice(f.ctx, ctm, pixmap)
+	fzEnableDeviceHints(f.ctx, device, fzNoCache)
+	defer fzDropDevice(f.ctx, device)
+
+	p.run(device, fzIdentity, cookie)
+
+	fzCloseDevice(f.ctx, device)
+
+	pixels := fzPixmapSamples(f.ctx, pixmap)
+	if pixels == nil {
+		return nil, ErrPixmapSamples
+	}
+
+	n := int(pixmap.N)
+	stride := int(pixmap.Stride)
+	rect := image.Rect(int(bbox.X0), int(bbox.Y0), int(bbox.X1), int(bbox.Y1))
+
+	return pixmapImage(opts.Colorspace, n, rect, stride, unsafe.Slice(pixels, stride*rect.Dy())), nil
+}
+
+// setAntiAlias sets the anti-aliasing levels of opts, returning a func that restores the previous levels.
+func (f *Document) setAntiAlias(opts RenderOptions) func() {
+	text, graphics := fzTextAaLevel(f.ctx), fzGraphicsAaLevel(f.ctx)
+
+	if bits, ok := aaLevel(opts.TextAntiAlias); ok {
+		fzSetTextAaLevel(f.ctx, bits)
+	}
+
+	if bits, ok := aaLevel(opts.GraphicsAntiAlias); ok {
+		fzSetGraphicsAaLevel(f.ctx, bits)
+	}
+
+	return func() {
+		fzSetTextAaLevel(f.ctx, text)
+		fzSetGraphicsAaLevel(f.ctx, graphics)
+	}
+}
+
+// RenderInto renders the page at the given DPI directly into dst, which must have the size of the rendered page.
+func (p *Page) RenderInto(dst *image.RGBA, dpi float64) error {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return ErrPageClosed
+	}
+
+	var bounds fzRect
+	bounds = boundPage(f.ctx, p.page)
+
+	var ctm fzMatrix
+	ctm = scale(float32(dpi/72), float32(dpi/72))
+
+	var bbox fzIRect
+	bounds = transformRect(bounds, ctm)
+	bbox = roundRect(bounds)
+
+	w, h := int(bbox.X1-bbox.X0), int(bbox.Y1-bbox.Y0)
+	if dst.Rect.Dx() != w || dst.Rect.Dy() != h || dst.Stride != 4*w || len(dst.Pix) < 4*w*h || w*h == 0 {
+		return ErrImageSize
+	}
+
+	var pinner runtime.Pinner
+	pinner.Pin(&dst.Pix[0])
+	defer pinner.Unpin()
+
+	pixmap := newPixmapWithBboxAndData(f.ctx, fzDeviceRgb(f.ctx), bbox, 1, &dst.Pix[0])
+	if pixmap == nil {
+		return ErrCreatePixmap
+	}
+
+	fzClearPixmapWithValue(f.ctx, pixmap, 0xff)
+	defer fzDropPixmap(f.ctx, pixmap)
+
+	device := newDrawDevice(f.ctx, ctm, pixmap)
+	fzEnableDeviceHints(f.ctx, device, fzNoCache)
+	defer fzDropDevice(f.ctx, device)
+
+	p.run(device, fzIdentity, nil)
+
+	fzCloseDevice(f.ctx, device)
+
+	return nil
+}
+
+// tileBounds returns the bounds of the page rendered at the given DPI, recording its display list.
+func (p *Page) tileBounds(dpi float64) (image.Rectangle, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return image.Rectangle{}, ErrPageClosed
+	}
+
+	if p.list == nil {
+		p.list = f.displayList(p.page, p.number, true)
+		if p.list == nil {
+			return image.Rectangle{}, ErrRunPageContents
+		}
+	}
+
+	var bbox fzIRect
+	bbox = roundRect(transformRect(boundPage(f.ctx, p.page), scale(float32(dpi/72), float32(dpi/72))))
+
+	return image.Rect(int(bbox.X0), int(bbox.Y0), int(bbox.X1), int(bbox.Y1)), nil
+}
+
+// renderTile returns the part r of the page rendered at the given DPI.
+func (p *Page) renderTile(dpi float64, r image.Rectangle) (image.Image, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return nil, ErrPageClosed
+	}
+
+	bbox := fzIRect{X0: int32(r.Min.X), Y0: int32(r.Min.Y), X1: int32(r.Max.X), Y1: int32(r.Max.Y)}
+
+	pixmap, err := p.renderBand(scale(float32(dpi/72), float32(dpi/72)), bbox, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fzDropPixmap(f.ctx, pixmap)
+
+	stride := int(pixmap.Stride)
+	pixels := unsafe.Slice(fzPixmapSamples(f.ctx, pixmap), stride*r.Dy())
+
+	return pixmapImage(ColorspaceRGB, 4, r, stride, pixels), nil
+}
+
+// renderBand returns an RGB pixmap of the part bbox of the page, in pixels, replaying its display list.
+func (p *Page) renderBand(ctm fzMatrix, bbox fzIRect, alpha int) (*fzPixmap, error) {
+	f := p.doc
+
+	pixmap := fzNewPixmap(f.ctx, fzDeviceRgb(f.ctx), int(bbox.X1-bbox.X0), int(bbox.Y1-bbox.Y0), nil, alpha)
+	if pixmap == nil {
+		return nil, ErrCreatePixmap
+	}
+
+	pixmap.X, pixmap.Y = bbox.X0, bbox.Y0
+
+	fzClearPixmapWithValue(f.ctx, pixmap, 0xff)
+
+	device := newDrawDevice(f.ctx, fzIdentity, pixmap)
+	fzEnableDeviceHints(f.ctx, device, fzNoCache)
+	defer fzDropDevice(f.ctx, device)
+
+	scissor := fzRect{X0: float32(bbox.X0), Y0: float32(bbox.Y0), X1: float32(bbox.X1), Y1: float32(bbox.Y1)}
+	runDisplayList(f.ctx, p.list, device, ctm, scissor, &fzCookie{})
+
+	fzCloseDevice(f.ctx, device)
+
+	return pixmap, nil
+}
+
+// WriteImage writes the page rendered at the given DPI to w, encoded in format band by band with bounded memory.
+// It returns ErrImageFormat for formats that cannot be written by bands.
+func (p *Page) WriteImage(dpi float64, format ImageFormat, w io.Writer) error {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return ErrPageClosed
+	}
+
+	if !format.banded() {
+		return ErrImageFormat
+	}
+
+	if p.list == nil {
+		p.list = f.displayList(p.page, p.number, true)
+		if p.list == nil {
+			return ErrRunPageContents
+		}
+	}
+
+	var ctm fzMatrix
+	ctm = scale(float32(dpi/72), float32(dpi/72))
+
+	var bbox fzIRect
+	bbox = roundRect(transformRect(boundPage(f.ctx, p.page), ctm))
+
+	out, o := newOutput(f.ctx, w)
+	if out == nil {
+		return ErrCreateOutput
+	}
+
+	var writer *fzBandWriter
+	switch format {
+	case ImageFormatPAM:
+		writer = fzNewPamBandWriter(f.ctx, out)
+	case ImageFormatPNM:
+		writer = fzNewPnmBandWriter(f.ctx, out)
+	case ImageFormatPSD:
+		writer = fzNewPsdBandWriter(f.ctx, out)
+	default:
+		writer = fzNewPngBandWriter(f.ctx, out)
+	}
+
+	if writer == nil {
+		dropOutput(f.ctx, out, o)
+		return ErrWriteOutput
+	}
+
+	fzWriteHeader(f.ctx, writer, int(bbox.X1-bbox.X0), int(bbox.Y1-bbox.Y0), 3, 0, int(dpi), int(dpi), p.number, fzDeviceRgb(f.ctx), nil)
+
+	var err error
+	for y := bbox.Y0; y < bbox.Y1 && err == nil; y += imageBandHeight {
+		band := bbox
+		band.Y0, band.Y1 = y, min(y+imageBandHeight, bbox.Y1)
+
+		var pixmap *fzPixmap
+		pixmap, err = p.renderBand(ctm, band, 0)
+		if err != nil {
+			break
+		}
+
+		fzWriteBand(f.ctx, writer, int(pixmap.Stride), int(pixmap.H), fzPixmapSamples(f.ctx, pixmap))
+		fzDropPixmap(f.ctx, pixmap)
+	}
+
+	if err == nil {
+		fzCloseBandWriter(f.ctx, writer)
+		fzCloseOutput(f.ctx, out)
+	}
+
+	fzDropBandWriter(f.ctx, writer)
+
+	if e := dropOutput(f.ctx, out, o); e != nil {
+		return e
+	}
+
+	return err
+}
+
+// ImageEncoded returns the page rendered at the given DPI and encoded in format. Quality from 1 to 100 applies to JPEG and JPX,
+// 0 selects the default.
+func (p *Page) ImageEncoded(dpi float64, format ImageFormat, quality int) ([]byte, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return nil, ErrPageClosed
+	}
+
+	buf, err := p.encode(dpi, format, quality)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fzDropBuffer(f.ctx, buf)
+
+	var data *uint8
+	size := fzBufferStorage(f.ctx, buf, &data)
+
+	ret := make([]byte, size)
+	copy(ret, unsafe.Slice(data, size))
+
+	return ret, nil
+}
+
+// WriteImageEncoded writes the page rendered at the given DPI and encoded in format to w, see ImageEncoded.
+func (p *Page) WriteImageEncoded(dpi float64, format ImageFormat, quality int, w io.Writer) error {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return ErrPageClosed
+	}
+
+	buf, err := p.encode(dpi, format, quality)
+	if err != nil {
+		return err
+	}
+
+	defer fzDropBuffer(f.ctx, buf)
+
+	var data *uint8
+	size := fzBufferStorage(f.ctx, buf, &data)
+
+	_, err = w.Write(unsafe.Slice(data, size))
+
+	return err
+}
+
+// encode renders the page at the given DPI into a pixmap suited to format and encodes it, the caller drops the buffer.
+func (p *Page) encode(dpi float64, format ImageFormat, quality int) (*fzBuffer, error) {
+	f := p.doc
+
+	var ctm fzMatrix
+	ctm = scale(float32(dpi/72), float32(dpi/72))
+
+	var bbox fzIRect
+	bbox = roundRect(transformRect(boundPage(f.ctx, p.page), ctm))
+
+	cs, alpha := format.pixmap()
+
+	colorspace := fzDeviceRgb(f.ctx)
+	if cs == ColorspaceCMYK {
+		colorspace = fzDeviceCmyk(f.ctx)
+	}
+
+	pixmap := fzNewPixmap(f.ctx, colorspace, int(bbox.X1-bbox.X0), int(bbox.Y1-bbox.Y0), nil, alpha)
+	if pixmap == nil {
+		return nil, ErrCreatePixmap
+	}
+
+	pixmap.X, pixmap.Y = bbox.X0, bbox.Y0
+
+	fzClearPixmapWithValue(f.ctx, pixmap, 0xff)
+	defer fzDropPixmap(f.ctx, pixmap)
+
+	device := newDrawDevice(f.ctx, ctm, pixmap)
+	fzEnableDeviceHints(f.ctx, device, fzNoCache)
+	defer fzDropDevice(f.ctx, device)
+
+	p.run(device, fzIdentity, nil)
+
+	fzCloseDevice(f.ctx, device)
+
+	params := fzColorParams{1, 1, 0, 0}
+	buf := newBufferFromPixmap(f.ctx, pixmap, format, params, imageQuality(quality))
+	if buf == nil {
+		return nil, ErrEncodeImage
+	}
+
+	return buf, nil
+}
+
+// Images returns the images drawn on the page, in drawing order.
+func (p *Page) Images() ([]EmbeddedImage, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return nil, ErrPageClosed
+	}
+
+	var images []EmbeddedImage
+
+	err := p.imageBlocks(func(img *fzImage, bbox fzRect) bool {
+		format := "png"
+		if t := imageOriginalType(f.ctx, img); t != 0 {
+			format = bytePtrToString(fzImageTypeName(t))
+		}
+
+		embedded := EmbeddedImage{
+			Rect:       goRect(bbox),
+			Width:      int(img.W),
+			Height:     int(img.H),
+			BPC:        int(img.Bpc),
+			Colorspace: bytePtrToString(fzColorspaceName(f.ctx, imageColorspace(img))),
+			Format:     format,
+			doc:        f,
+			page:       p.number,
+			index:      len(images),
+		}
+		embedded.XRes, embedded.YRes = imageResolution(embedded.Width, embedded.Height, embedded.Rect)
+
+		images = append(images, embedded)
+
+		return true
+	})
+
+	return images, err
+}
+
+// imageData returns the original or PNG encoded bytes of the image at index in drawing order.
+func (p *Page) imageData(index int) ([]byte, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return nil, ErrPageClosed
+	}
+
+	var data []byte
+	found := false
+	i := 0
+
+	err := p.imageBlocks(func(img *fzImage, bbox fzRect) bool {
+		if i < index {
+			i++
+			return true
+		}
+
+		found = true
+
+		var buf *fzBuffer
+		if imageOriginalType(f.ctx, img) != 0 {
+			buf = fzCompressedImageBuffer(f.ctx, img).Buffer
+		} else {
+			buf = newBufferFromImageAsPNG(f.ctx, img, fzColorParams{1, 1, 0, 0})
+			if buf == nil {
+				return false
+			}
+
+			defer fzDropBuffer(f.ctx, buf)
+		}
+
+		var ptr *uint8
+		size := fzBufferStorage(f.ctx, buf, &ptr)
+
+		data = make([]byte, size)
+		copy(data, unsafe.Slice(ptr, size))
+
+		return false
+	})
+
+	switch {
+	case err != nil:
+		return nil, err
+	case !found:
+		return nil, ErrImageMissing
+	case data == nil:
+		return nil, ErrEncodeImage
+	}
+
+	return data, nil
+}
+
+// imageBlocks calls fn with each image drawn on the page and its bbox until fn returns false, the images are valid only during the call.
+func (p *Page) imageBlocks(fn func(img *fzImage, bbox fzRect) bool) error {
+	f := p.doc
+
+	text, err := p.newSTextPage(TextOptions{PreserveImages: true}, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	defer fzDropStextPage(f.ctx, text)
+
+	var walk func(blk *fzStextBlock) bool
+
+	walk = func(blk *fzStextBlock) bool {
+		for ; blk != nil; blk = blk.Next {
+			switch blk.Type {
+			case fzStextBlockStruct:
+				if down := blk.down(); down != nil && !walk(down.FirstBlock) {
+					return false
+				}
+			case fzStextBlockImage:
+				if !fn(blk.image(), blk.Bbox) {
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+
+	walk(text.FirstBlock)
+
+	return nil
+}
+
+// imageOriginalType returns the type of the compressed bytes of an image if they form a standalone image file, or 0.
+func imageOriginalType(ctx *fzContext, img *fzImage) int {
+	cbuf := fzCompressedImageBuffer(ctx, img)
+	if cbuf == nil || cbuf.Buffer == nil || cbuf.Type < fzImageBmp {
+		return 0
+	}
+
+	if cbuf.Type == fzImageJbig2 && cbuf.jbig2Embedded() != 0 {
+		return 0
+	}
+
+	return int(cbuf.Type)
+}
+
+// TextWithOptions returns text of the page, extracted with the given options.
+func (p *Page) TextWithOptions(opts TextOptions) (string, error) {
+	return p.text(opts, nil)
+}
+
+// TextContext returns text of the page, aborting when ctx is done and reporting to progress if not nil.
+// It returns the text along with ErrPartialRender if errors were encountered while extracting it.
+func (p *Page) TextContext(ctx context.Context, progress ProgressFunc) (string, error) {
+	var cookie fzCookie
+
+	stop := watchCookie(ctx, progress, &cookie)
+	str, err := p.text(TextOptions{}, &cookie)
+	stop()
+
+	err = cookieErr(ctx, &cookie, err)
+	if ctx.Err() != nil {
+		return "", err
+	}
+
+	return str, err
+}
+
+// text extracts text of the page with the given options, cookie may be nil.
+func (p *Page) text(opts TextOptions, cookie *fzCookie) (string, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return "", ErrPageClosed
+	}
+
+	text, err := p.newSTextPage(opts, 0, cookie)
+	if err != nil {
+		return "", err
+	}
+
+	defer fzDropStextPage(f.ctx, text)
+
+	buf := fzNewBufferFromStextPage(f.ctx, text)
+	defer fzDropBuffer(f.ctx, buf)
+
+	ret := fzStringFromBuffer(f.ctx, buf)
+
+	return bytePtrToString(ret), nil
+}
+
+// Text returns text of the page.
+func (p *Page) Text() (string, error) {
+	return p.TextWithOptions(TextOptions{})
+}
+
+// HTML returns html of the page.
+func (p *Page) HTML(header bool) (string, error) {
+	return p.HTMLWithOptions(header, TextOptions{PreserveImages: true})
+}
+
+// HTMLWithOptions returns html of the page, extracted with the given options.
+func (p *Page) HTMLWithOptions(header bool, opts TextOptions) (string, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return "", ErrPageClosed
+	}
+
+	text, err := p.newSTextPage(opts, 0, nil)
+	if err != nil {
+		return "", err
+	}
+
+	defer fzDropStextPage(f.ctx, text)
+
+	buf := fzNewBuffer(f.ctx, 1024)
+	defer fzDropBuffer(f.ctx, buf)
+
+	out := fzNewOutputWithBuffer(f.ctx, buf)
+	defer fzDropOutput(f.ctx, out)
+
+	if header {
+		fzPrintStextHeaderAsHTML(f.ctx, out)
+	}
+	fzPrintStextPageAsHTML(f.ctx, out, text, p.number)
+	if header {
+		fzPrintStextTrailerAsHTML(f.ctx, out)
+	}
+
+	fzCloseOutput(f.ctx, out)
+
+	ret := fzStringFromBuffer(f.ctx, buf)
+
+	return bytePtrToString(ret), nil
+}
+
+// SVG returns svg document of the page.
+func (p *Page) SVG() (string, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return "", ErrPageClosed
+	}
+
+	var bounds fzRect
+	bounds = boundPage(f.ctx, p.page)
+
+	var ctm fzMatrix
+	ctm = scale(float32(72.0/72), float32(72.0/72))
+	bounds = transformRect(bounds, ctm)
+
+	buf := fzNewBuffer(f.ctx, 1024)
+	defer fzDropBuffer(f.ctx, buf)
+
+	out := fzNewOutputWithBuffer(f.ctx, buf)
+	defer fzDropOutput(f.ctx, out)
+
+	device := newSvgDevice(f.ctx, out, bounds.X1-bounds.X0, bounds.Y1-bounds.Y0, fzSvgTextAsPath, 1)
+	fzEnableDeviceHints(f.ctx, device, fzNoCache)
+	defer fzDropDevice(f.ctx, device)
+
+	p.run(device, ctm, nil)
+
+	fzCloseDevice(f.ctx, device)
+	fzCloseOutput(f.ctx, out)
+
+	ret := fzStringFromBuffer(f.ctx, buf)
+
+	return bytePtrToString(ret), nil
+}
+
+// Run draws the page with its annotations on dev, transformed by m from page space to the space of the page being written.
+// A page of another document than the one of the writer is copied into the writer as a one-page PDF,
+// which keeps its graphics, text and images but not its links.
+func (p *Page) Run(dev *Device, m Matrix) error {
+	if dev == nil {
+		return ErrDeviceClosed
+	}
+
+	w := dev.w
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if dev.dev == nil {
+		return ErrDeviceClosed
+	}
+
+	if p.doc == w.doc {
+		f := p.doc
+
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+
+		if p.page == nil {
+			return ErrPageClosed
+		}
+
+		var cookie fzCookie
+		runPage(f.ctx, p.page, dev.dev, fzMatrixOf(m), &cookie)
+
+		if cookie.Errors > 0 {
+			return ErrRunPageContents
+		}
+
+		return nil
+	}
+
+	data, bounds, err := p.pdf()
+	if err != nil {
+		return err
+	}
+
+	f := w.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	buf := fzNewBufferFromCopiedData(f.ctx, unsafe.SliceData(data), uint64(len(data)))
+	if buf == nil {
+		return ErrRunPageContents
+	}
+
+	defer fzDropBuffer(f.ctx, buf)
+
+	doc := fzOpenDocumentWithBuffer(f.ctx, "application/pdf", buf)
+	if doc == nil {
+		return ErrOpenDocument
+	}
+
+	defer fzDropDocument(f.ctx, doc)
+
+	page := fzLoadPage(f.ctx, doc, 0)
+	if page == nil {
+		return ErrLoadPage
+	}
+
+	defer fzDropPage(f.ctx, page)
+
+	r := goRect(boundPage(f.ctx, page))
+	ctm := Translate(bounds.X0-r.X0, bounds.Y0-r.Y0).Concat(m)
+
+	var cookie fzCookie
+	runPage(f.ctx, page, dev.dev, fzMatrixOf(ctm), &cookie)
+
+	if cookie.Errors > 0 {
+		return ErrRunPageContents
+	}
+
+	return nil
+}
+
+// pdf returns the page written as a one-page PDF in the context of its document, and its bounds.
+func (p *Page) pdf() ([]byte, Rect, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return nil, Rect{}, ErrPageClosed
+	}
+
+	buf := fzNewBuffer(f.ctx, 8192)
+	if buf == nil {
+		return nil, Rect{}, ErrCreateOutput
+	}
+
+	defer fzDropBuffer(f.ctx, buf)
+
+	out := fzNewOutputWithBuffer(f.ctx, buf)
+	if out == nil {
+		return nil, Rect{}, ErrCreateOutput
+	}
+
+	// The writer owns out from here on, and drops it even if it cannot be created.
+	wri := fzNewDocumentWriterWithOutput(f.ctx, out, "pdf", "")
+	if wri == nil {
+		return nil, Rect{}, ErrCreateWriter
+	}
+
+	defer fzDropDocumentWriter(f.ctx, wri)
+
+	bounds := boundPage(f.ctx, p.page)
+
+	device := beginPage(f.ctx, wri, bounds)
+	if device == nil {
+		return nil, Rect{}, ErrWritePage
+	}
+
+	var cookie fzCookie
+	runPage(f.ctx, p.page, device, fzIdentity, &cookie)
+
+	if err := endPage(f.ctx, wri); err != nil {
+		return nil, Rect{}, err
+	}
+
+	if cookie.Errors > 0 {
+		return nil, Rect{}, ErrRunPageContents
+	}
+
+	if err := closeDocumentWriter(f.ctx, wri); err != nil {
+		return nil, Rect{}, err
+	}
+
+	var data *uint8
+	size := fzBufferStorage(f.ctx, buf, &data)
+
+	ret := make([]byte, size)
+	copy(ret, unsafe.Slice(data, size))
+
+	return ret, goRect(bounds), nil
+}
+
+// Links returns slice of links on the page.
+func (p *Page) Links() ([]Link, error) {
+	f := p.doc
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if p.page == nil {
+		return nil, ErrPageClosed
+	}
+
+	links := fzLoadLinks(f.ctx, p.page)
+	defer fzDropLink(f.ctx, links)
+
+	linkCount := 0
+	for currLink := links; currLink != nil; currLink = currLink.Next {
+		linkCount++
+	}
+
+	if linkCount == 0 {
+		return nil, nil
+	}
+
+	gLinks := make([]Link, linkCount)
+
+	currLink := links
+	for i := 0; i < linkCount; i++ {
+		gLinks[i] = Link{
+			URI:  bytePtrToString((*uint8)(unsafe.Pointer(currLink.Uri))),
+			Rect: goRect(currLink.Rect),
+		}
+
+		if fzIsExternalLink(f.ctx, (*uint8)(unsafe.Pointer(currLink.Uri))) != 0 {
+			gLinks[i].External = true
+			gLinks[i].Dest.Page = -1
+		} else {
+			dest := resolveLinkDest(f.ctx, f.doc, (*uint8)(unsafe.Pointer(currLink.Uri)))
 
-	lookup := func(key string) string {
-		buf := make([]byte, 256)
-		fzLookupMetadata(f.ctx, f.doc, key, unsafe.SliceData(buf), len(buf))
+			page := -1
+			if dest.Loc.Page >= 0 {
+				page = pageNumberFromLocation(f.ctx, f.doc, dest.Loc)
+			}
 
-		return string(buf)
-	}
+			gLinks[i].Dest = LinkDest{
+				Page:    page,
+				Chapter: int(dest.Loc.Chapter),
+				X:       float64(dest.X),
+				Y:       float64(dest.Y),
+				Zoom:    float64(dest.Zoom),
+				Type:    LinkDestType(dest.Type),
+			}
+		}
 
-	data["format"] = lookup("format")
-	data["encryption"] = lookup("encryption")
-	data["title"] = lookup("info:Title")
-	data["author"] = lookup("info:Author")
-	data["subject"] = lookup("info:Subject")
-	data["keywords"] = lookup("info:Keywords")
-	data["creator"] = lookup("info:Creator")
-	data["producer"] = lookup("info:Producer")
-	data["creationDate"] = lookup("info:CreationDate")
-	data["modDate"] = lookup("info:ModDate")
+		currLink = currLink.Next
+	}
 
-	return data
+	return gLinks, nil
 }
 
-// Bound gives the Bounds of a given Page in the document.
-func (f *Document) Bound(pageNumber int) (image.Rectangle, error) {
+// Label returns the label of the page, e.g. "xii", or the page number counting from 1 if the document has no page labels.
+func (p *Page) Label() (string, error) {
+	f := p.doc
+
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
 
-	if pageNumber >= f.NumPage() {
-		return image.Rectangle{}, ErrPageMissing
-	}
-
-	page := fzLoadPage(f.ctx, f.doc, pageNumber)
-	if page == nil {
-		return image.Rectangle{}, ErrLoadPage
+	if p.page == nil {
+		return "", ErrPageClosed
 	}
 
-	defer fzDropPage(f.ctx, page)
-
-	var bounds fzRect
-	bounds = boundPage(f.ctx, page)
+	var buf [pageLabelSize]uint8
 
-	return image.Rect(int(bounds.X0), int(bounds.Y0), int(bounds.X1), int(bounds.Y1)), nil
+	return bytePtrToString(fzPageLabel(f.ctx, p.page, &buf[0], len(buf))), nil
 }
 
-// Close closes the underlying fitz document.
-func (f *Document) Close() error {
-	if f.stream != nil {
-		fzDropStream(f.ctx, f.stream)
-	}
-
-	fzDropDocument(f.ctx, f.doc)
-	fzDropContext(f.ctx)
+// Close releases the page.
+func (p *Page) Close() error {
+	p.doc.mtx.Lock()
+	defer p.doc.mtx.Unlock()
 
-	f.data = nil
+	p.drop()
 
 	return nil
 }
 
-var (
-	libmupdf uintptr
-
-	fzNewSvgDevice             func(ctx *fzContext, out *fzOutput, pageWidth, pageHeight float32, textFormat, reuseImages int) *fzDevice
-	fzNewContextImp            func(alloc *fzAllocContext, locks *fzLocksContext, maxStore uint64, version string) *fzContext
-	fzDropContext              func(ctx *fzContext)
-	fzOpenDocument             func(ctx *fzContext, filename string) *fzDocument
-	fzOpenDocumentWithStream   func(ctx *fzContext, magic string, stream *fzStream) *fzDocument
-	fzOpenMemory               func(ctx *fzContext, data *uint8, len uint64) *fzStream
-	fzDropStream               func(ctx *fzContext, stm *fzStream)
-	fzRegisterDocumentHandlers func(ctx *fzContext)
-	fzNeedsPassword            func(ctx *fzContext, doc *fzDocument) int
-	fzDropDocument             func(ctx *fzContext, doc *fzDocument)
-	fzCountPages               func(ctx *fzContext, doc *fzDocument) int
-	fzLoadPage                 func(ctx *fzContext, doc *fzDocument, number int) *fzPage
-	fzDropPage                 func(ctx *fzContext, page *fzPage)
-	fzNewPixmap                func(ctx *fzContext, colorspace *fzColorspace, w, h int, seps *fzSeparations, alpha int) *fzPixmap
-	fzDropPixmap               func(ctx *fzContext, pix *fzPixmap)
-	fzPixmapSamples            func(ctx *fzContext, pix *fzPixmap) *uint8
-	fzClearPixmapWithValue     func(ctx *fzContext, pix *fzPixmap, value int)
-	fzEnableDeviceHints        func(ctx *fzContext, dev *fzDevice, hints int)
-	fzDropDevice               func(ctx *fzContext, dev *fzDevice)
-	fzCloseDevice              func(ctx *fzContext, dev *fzDevice)
-	fzDeviceRgb                func(ctx *fzContext) *fzColorspace
-	fzNewBuffer                func(ctx *fzContext, size uint64) *fzBuffer
-	fzDropBuffer               func(ctx *fzContext, buf *fzBuffer)
-	fzBufferStorage            func(ctx *fzContext, buf *fzBuffer, data **uint8) uint64
-	fzStringFromBuffer         func(ctx *fzContext, buf *fzBuffer) *uint8
-	fzLoadLinks                func(ctx *fzContext, page *fzPage) *fzLink
-	fzDropLink                 func(ctx *fzContext, link *fzLink)
-	fzDropStextPage            func(ctx *fzContext, page *fzStextPage)
-	fzNewStextDevice           func(ctx *fzContext, page *fzStextPage, options *fzStextOptions) *fzDevice
-	fzNewBufferFromStextPage   func(ctx *fzContext, page *fzStextPage) *fzBuffer
-	fzLookupMetadata           func(ctx *fzContext, doc *fzDocument, key string, buf *uint8, size int) int
-	fzLoadOutline              func(ctx *fzContext, doc *fzDocument) *fzOutline
-	fzDropOutline              func(ctx *fzContext, outline *fzOutline)
-	fzNewOutputWithBuffer      func(ctx *fzContext, buf *fzBuffer) *fzOutput
-	fzDropOutput               func(ctx *fzContext, out *fzOutput)
-	fzCloseOutput              func(ctx *fzContext, out *fzOutput)
-	fzPrintStextPageAsHTML     func(ctx *fzContext, out *fzOutput, page *fzStextPage, id int)
-	fzPrintStextHeaderAsHTML   func(ctx *fzContext, out *fzOutput)
-	fzPrintStextTrailerAsHTML  func(ctx *fzContext, out *fzOutput)
-	fzSetWarningCallback       func(ctx *fzContext, cb uintptr, user *byte)
-
-	silentWarning uintptr
-)
-
-// silenceWarnings installs a no-op warning callback, suppressing MuPDF's stderr warnings.
-func silenceWarnings(ctx *fzContext) {
-	fzSetWarningCallback(ctx, silentWarning, nil)
-}
-
-func init() {
-	libmupdf = loadLibrary()
-
-	if os.Getenv("FZ_VERSION") != "" {
-		FzVersion = os.Getenv("FZ_VERSION")
+// drop releases the page, the caller holds the document mutex.
+func (p *Page) drop() {
+	if p.page != nil {
+		fzDropDisplayList(p.doc.ctx, p.list)
+		fzDropPage(p.doc.ctx, p.page)
+		p.list = nil
+		p.page = nil
 	}
+}
 
-	registerStructFuncs(libmupdf)
+// DisplayList records the page once into a display list, that later renders, text extraction and SVG output of the page replay.
+// The list is kept until the page is closed, and shared with the display list cache of the document.
+func (p *Page) DisplayList() error {
+	f := p.doc
 
-	purego.RegisterLibFunc(&fzSetWarningCallback, libmupdf, "fz_set_warning_callback")
-	silentWarning = purego.NewCallback(func(user *byte, message *byte) {})
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
 
-	purego.RegisterLibFunc(&fzNewSvgDevice, libmupdf, "fz_new_svg_device")
-	purego.RegisterLibFunc(&fzNewContextImp, libmupdf, "fz_new_context_imp")
-	purego.RegisterLibFunc(&fzDropContext, libmupdf, "fz_drop_context")
-	purego.RegisterLibFunc(&fzOpenDocument, libmupdf, "fz_open_document")
-	purego.RegisterLibFunc(&fzOpenDocumentWithStream, libmupdf, "fz_open_document_with_stream")
-	purego.RegisterLibFunc(&fzOpenMemory, libmupdf, "fz_open_memory")
-	purego.RegisterLibFunc(&fzDropStream, libmupdf, "fz_drop_stream")
-	purego.RegisterLibFunc(&fzRegisterDocumentHandlers, libmupdf, "fz_register_document_handlers")
-	purego.RegisterLibFunc(&fzNeedsPassword, libmupdf, "fz_needs_password")
-	purego.RegisterLibFunc(&fzDropDocument, libmupdf, "fz_drop_document")
-	purego.RegisterLibFunc(&fzCountPages, libmupdf, "fz_count_pages")
-	purego.RegisterLibFunc(&fzLoadPage, libmupdf, "fz_load_page")
-	purego.RegisterLibFunc(&fzDropPage, libmupdf, "fz_drop_page")
-	purego.RegisterLibFunc(&fzNewPixmap, libmupdf, "fz_new_pixmap")
-	purego.RegisterLibFunc(&fzDropPixmap, libmupdf, "fz_drop_pixmap")
-	purego.RegisterLibFunc(&fzPixmapSamples, libmupdf, "fz_pixmap_samples")
-	purego.RegisterLibFunc(&fzClearPixmapWithValue, libmupdf, "fz_clear_pixmap_with_value")
-	purego.RegisterLibFunc(&fzEnableDeviceHints, libmupdf, "fz_enable_device_hints")
-	purego.RegisterLibFunc(&fzDropDevice, libmupdf, "fz_drop_device")
-	purego.RegisterLibFunc(&fzCloseDevice, libmupdf, "fz_close_device")
-	purego.RegisterLibFunc(&fzDeviceRgb, libmupdf, "fz_device_rgb")
-	purego.RegisterLibFunc(&fzNewBuffer, libmupdf, "fz_new_buffer")
-	purego.RegisterLibFunc(&fzDropBuffer, libmupdf, "fz_drop_buffer")
-	purego.RegisterLibFunc(&fzBufferStorage, libmupdf, "fz_buffer_storage")
-	purego.RegisterLibFunc(&fzStringFromBuffer, libmupdf, "fz_string_from_buffer")
-	purego.RegisterLibFunc(&fzLoadLinks, libmupdf, "fz_load_links")
-	purego.RegisterLibFunc(&fzDropLink, libmupdf, "fz_drop_link")
-	purego.RegisterLibFunc(&fzDropStextPage, libmupdf, "fz_drop_stext_page")
-	purego.RegisterLibFunc(&fzNewStextDevice, libmupdf, "fz_new_stext_device")
-	purego.RegisterLibFunc(&fzNewBufferFromStextPage, libmupdf, "fz_new_buffer_from_stext_page")
-	purego.RegisterLibFunc(&fzLookupMetadata, libmupdf, "fz_lookup_metadata")
-	purego.RegisterLibFunc(&fzLoadOutline, libmupdf, "fz_load_outline")
-	purego.RegisterLibFunc(&fzDropOutline, libmupdf, "fz_drop_outline")
-	purego.RegisterLibFunc(&fzNewOutputWithBuffer, libmupdf, "fz_new_output_with_buffer")
-	purego.RegisterLibFunc(&fzDropOutput, libmupdf, "fz_drop_output")
-	purego.RegisterLibFunc(&fzCloseOutput, libmupdf, "fz_close_output")
-	purego.RegisterLibFunc(&fzPrintStextPageAsHTML, libmupdf, "fz_print_stext_page_as_html")
-	purego.RegisterLibFunc(&fzPrintStextHeaderAsHTML, libmupdf, "fz_print_stext_header_as_html")
-	purego.RegisterLibFunc(&fzPrintStextTrailerAsHTML, libmupdf, "fz_print_stext_trailer_as_html")
+	if p.page == nil {
+		return ErrPageClosed
+	}
 
-	ver := version()
-	if ver != "" {
-		FzVersion = ver
+	if p.list == nil {
+		p.list = f.displayList(p.page, p.number, true)
+		if p.list == nil {
+			return ErrRunPageContents
+		}
 	}
+
+	return nil
 }
 
-// libVersionRe matches the library version in fz_new_context_imp's mismatch message.
-var libVersionRe = regexp.MustCompile(`library \(([0-9]+\.[0-9]+\.[0-9]+)\)`)
+// newSTextPage extracts the structured text of the page with opts and the extra fz_stext_options flags, replaying its
+// display list when one is recorded or cached, cookie may be nil. The caller holds the document mutex and drops the text.
+func (p *Page) newSTextPage(opts TextOptions, flags int, cookie *fzCookie) (*fzStextPage, error) {
+	f := p.doc
 
-// version finds the libmupdf build version fz_new_context_imp needs: FzVersion, then the mismatch message, then a scan.
-func version() string {
-	var ok bool
-	out := captureStderr(func() { ok = probeVersion(FzVersion) })
-	if ok {
-		return FzVersion
+	sopts, err := f.stextOptions(opts)
+	if err != nil {
+		return nil, err
 	}
 
-	if m := libVersionRe.FindStringSubmatch(out); m != nil && m[1] != FzVersion {
-		captureStderr(func() { ok = probeVersion(m[1]) })
-		if ok {
-			return m[1]
-		}
-	}
+	sopts.Flags |= int32(flags)
 
-	var found string
-	captureStderr(func() { found = scanVersion() })
+	text := newStextPage(f.ctx, boundPage(f.ctx, p.page))
 
-	return found
-}
+	device := fzNewStextDevice(f.ctx, text, &sopts)
+	fzEnableDeviceHints(f.ctx, device, fzNoCache)
+	defer fzDropDevice(f.ctx, device)
 
-// probeVersion reports whether ver is the libmupdf build version.
-func probeVersion(ver string) bool {
-	ctx := fzNewContextImp(nil, nil, uint64(MaxStore), ver)
-	if ctx == nil {
-		return false
-	}
+	p.run(device, fzIdentity, cookie)
 
-	fzDropContext(ctx)
+	fzCloseDevice(f.ctx, device)
 
-	return true
+	return text, nil
 }
 
-// scanVersion brute-forces minor/patch combinations around FzVersion as a fallback.
-func scanVersion() string {
-	s := strings.Split(FzVersion, ".")
-	if len(s) != 3 {
-		return ""
-	}
+// run runs the page on device, replaying its display list when one is recorded or cached.
+func (p *Page) run(device *fzDevice, ctm fzMatrix, cookie *fzCookie) {
+	f := p.doc
 
-	minor, err := strconv.Atoi(s[1])
-	if err != nil {
-		return ""
+	if cookie == nil {
+		cookie = &fzCookie{}
 	}
 
-	for m := minor + 2; m >= 0; m-- {
-		for p := 12; p >= 0; p-- {
-			ver := s[0] + "." + strconv.Itoa(m) + "." + strconv.Itoa(p)
-			if ver == FzVersion {
-				continue
-			}
+	if p.list == nil {
+		p.list = f.displayList(p.page, p.number, false)
+	}
 
-			if probeVersion(ver) {
-				return ver
-			}
-		}
+	if p.list != nil {
+		runDisplayList(f.ctx, p.list, device, ctm, fzInfiniteRect, cookie)
+		return
 	}
 
-	return ""
+	runPageContentsWithCookie(f.ctx, p.page, device, ctm, cookie)
 }
 
-// scale ports fz_scale.
-func scale(sx, sy float32) fzMatrix {
-	return fzMatrix{A: sx, D: sy}
-}
+// displayList returns a new reference to the display list of page, from the cache or recorded and cached within its capacity.
+// Without a cache it records only when force is set, and returns nil otherwise.
+func (f *Document) displayList(page *fzPage, pageNumber int, force bool) *fzDisplayList {
+	if l := f.lists.get(pageNumber); l != nil {
+		return fzKeepDisplayList(f.ctx, (*fzDisplayList)(l))
+	}
 
-// transformRect ports fz_transform_rect.
-func transformRect(r fzRect, m fzMatrix) fzRect {
-	if isInfiniteRect(r) {
-		return r
+	if f.lists.capacity <= 0 && !force {
+		return nil
 	}
 
-	const eps = 1.19209290e-07 // FLT_EPSILON
+	list := newDisplayList(f.ctx, boundPage(f.ctx, page))
+	if list == nil {
+		return nil
+	}
 
-	if absF32(m.B) < eps && absF32(m.C) < eps {
-		if m.A < 0 {
-			r.X0, r.X1 = r.X1, r.X0
-		}
-		if m.D < 0 {
-			r.Y0, r.Y1 = r.Y1, r.Y0
-		}
-		r.X0, r.Y0 = transformPointXY(r.X0, r.Y0, m)
-		r.X1, r.Y1 = transformPointXY(r.X1, r.Y1, m)
+	device := fzNewListDevice(f.ctx, list)
+	runPageContents(f.ctx, page, device, fzIdentity)
+	fzCloseDevice(f.ctx, device)
+	fzDropDevice(f.ctx, device)
 
-		return r
-	} else if absF32(m.A) < eps && absF32(m.D) < eps {
-		if m.B < 0 {
-			r.X0, r.X1 = r.X1, r.X0
-		}
-		if m.C < 0 {
-			r.Y0, r.Y1 = r.Y1, r.Y0
+	if f.lists.capacity > 0 {
+		fzKeepDisplayList(f.ctx, list)
+		for _, l := range f.lists.add(pageNumber, unsafe.Pointer(list)) {
+			fzDropDisplayList(f.ctx, (*fzDisplayList)(l))
 		}
-		r.X0, r.Y0 = transformPointXY(r.X0, r.Y0, m)
-		r.X1, r.Y1 = transformPointXY(r.X1, r.Y1, m)
-
-		return r
 	}
 
-	invalid := r.X0 > r.X1 || r.Y0 > r.Y1
-	sx, sy := transformPointXY(r.X0, r.Y0, m)
-	tx, ty := transformPointXY(r.X0, r.Y1, m)
-	ux, uy := transformPointXY(r.X1, r.Y1, m)
-	vx, vy := transformPointXY(r.X1, r.Y0, m)
-	r.X0, r.X1 = min(sx, tx, ux, vx), max(sx, tx, ux, vx)
-	r.Y0, r.Y1 = min(sy, ty, uy, vy), max(sy, ty, uy, vy)
+	return list
+}
+
+// SetDisplayListCache sets the number of page display lists cached by the document, 0 disables the cache.
+// The cache is bounded by a number of pages rather than bytes, as MuPDF does not report the memory a display list holds.
+func (f *Document) SetDisplayListCache(n int) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
 
-	if invalid {
-		r.X0, r.X1 = r.X1, r.X0
-		r.Y0, r.Y1 = r.Y1, r.Y0
+	for _, l := range f.lists.setCapacity(n) {
+		fzDropDisplayList(f.ctx, (*fzDisplayList)(l))
 	}
+}
 
-	return r
+func goPoint(p fzPoint) Point {
+	return Point{X: float64(p.X), Y: float64(p.Y)}
 }
 
-// roundRect ports fz_round_rect.
-func roundRect(r fzRect) fzIRect {
-	const minSafe, maxSafe = -16777216, 16777216
-
-	clamp := func(f float64) int32 {
-		switch {
-		case f < minSafe:
-			return minSafe
-		case f > maxSafe:
-			return maxSafe
-		default:
-			return int32(f)
-		}
-	}
+func goRect(r fzRect) Rect {
+	return Rect{X0: float64(r.X0), Y0: float64(r.Y0), X1: float64(r.X1), Y1: float64(r.Y1)}
+}
 
-	return fzIRect{
-		X0: clamp(math.Floor(float64(r.X0) + 0.001)),
-		Y0: clamp(math.Floor(float64(r.Y0) + 0.001)),
-		X1: clamp(math.Ceil(float64(r.X1) - 0.001)),
-		Y1: clamp(math.Ceil(float64(r.Y1) - 0.001)),
-	}
+func goLocation(loc fzLocation) Location {
+	return Location{Chapter: int(loc.Chapter), Page: int(loc.Page)}
 }
 
-func transformPointXY(x, y float32, m fzMatrix) (float32, float32) {
-	return x*m.A + y*m.C + m.E, x*m.B + y*m.D + m.F
+func fzLocationOf(loc Location) fzLocation {
+	return fzLocation{Chapter: int32(loc.Chapter), Page: int32(loc.Page)}
 }
 
-func isInfiniteRect(r fzRect) bool {
-	const minInf, maxInf = -2147483648, 0x7fffff80
+func goQuad(q fzQuad) Quad {
+	return Quad{UL: goPoint(q.Ul), UR: goPoint(q.Ur), LL: goPoint(q.Ll), LR: goPoint(q.Lr)}
+}
 
-	return r.X0 == minInf && r.X1 == maxInf && r.Y0 == minInf && r.Y1 == maxInf
+func fzPointOf(p Point) fzPoint {
+	return fzPoint{X: float32(p.X), Y: float32(p.Y)}
 }
 
-func absF32(x float32) float32 {
-	if x < 0 {
-		return -x
-	}
+func fzRectOf(r Rect) fzRect {
+	return fzRect{X0: float32(r.X0), Y0: float32(r.Y0), X1: float32(r.X1), Y1: float32(r.Y1)}
+}
+
+func goMatrix(m fzMatrix) Matrix {
+	return Matrix{A: float64(m.A), B: float64(m.B), C: float64(m.C), D: float64(m.D), E: float64(m.E), F: float64(m.F)}
+}
 
-	return x
+func fzMatrixOf(m Matrix) fzMatrix {
+	return fzMatrix{A: float32(m.A), B: float32(m.B), C: float32(m.C), D: float32(m.D), E: float32(m.E), F: float32(m.F)}
 }
 
 func newSvgDevice(ctx *fzContext, out *fzOutput, pageWidth, pageHeight float32, textFormat, reuseImages int) *fzDevice {
@@ -877,15 +2949,22 @@ func newSvgDevice(ctx *fzContext, out *fzOutput, pageWidth, pageHeight float32,
 }
 
 const (
-	fzNoCache             = 2
-	fzStextPreserveImages = 4
-	fzSvgTextAsPath       = 0
-	fzStextBlockText      = 0
-	fzStextBlockImage     = 1
+	fzNoCache       = 2
+	fzSvgTextAsPath = 0
+
+	fzStructureTable = 29
+	fzStructureTR    = 30
+	fzStructureTH    = 31
+	fzStructureTD    = 32
+
+	fzImageBmp   = 7
+	fzImageJbig2 = 9
 )
 
 var fzIdentity = fzMatrix{A: 1, B: 0, C: 0, D: 1, E: 0, F: 0}
 
+var fzInfiniteRect = fzRect{X0: -2147483648, Y0: -2147483648, X1: 0x7fffff80, Y1: 0x7fffff80}
+
 type fzContext struct {
 	User          *byte
 	Alloc         fzAllocContext
@@ -1017,6 +3096,18 @@ type fzRect struct {
 	Y1 float32
 }
 
+type fzPoint struct {
+	X float32
+	Y float32
+}
+
+type fzQuad struct {
+	Ul fzPoint
+	Ur fzPoint
+	Ll fzPoint
+	Lr fzPoint
+}
+
 type fzIRect struct {
 	X0 int32
 	Y0 int32
@@ -1041,6 +3132,14 @@ type fzCookie struct {
 	Incomplete int32
 }
 
+type fzDocumentWriter struct {
+	BeginPage   *[0]byte
+	EndPage     *[0]byte
+	CloseWriter *[0]byte
+	DropWriter  *[0]byte
+	Dev         *fzDevice
+}
+
 type fzDevice struct {
 	Refs                  int32
 	Hints                 int32
@@ -1147,6 +3246,16 @@ type fzLink struct {
 	Drop   *[0]byte
 }
 
+type fzLinkDest struct {
+	Loc  fzLocation
+	Type int32
+	X    float32
+	Y    float32
+	W    float32
+	H    float32
+	Zoom float32
+}
+
 type fzStextPage struct {
 	Refs       int32
 	_          [4]byte
@@ -1159,6 +3268,7 @@ type fzStextPage struct {
 type fzStextOptions struct {
 	Flags int32
 	Scale float32
+	Clip  fzRect
 }
 
 type fzStextBlock struct {
@@ -1170,10 +3280,74 @@ type fzStextBlock struct {
 	Next *fzStextBlock
 }
 
+// firstLine returns u.t.first_line of a text block.
+func (b *fzStextBlock) firstLine() *fzStextLine {
+	return *(**fzStextLine)(unsafe.Pointer(&b.U[0]))
+}
+
+// image returns u.i.image of an image block.
+func (b *fzStextBlock) image() *fzImage {
+	return *(**fzImage)(unsafe.Pointer(&b.U[24]))
+}
+
+// down returns u.s.down of a structure block.
+func (b *fzStextBlock) down() *fzStextStruct {
+	return *(**fzStextStruct)(unsafe.Pointer(&b.U[0]))
+}
+
+type fzStextStruct struct {
+	Up         *fzStextBlock
+	Parent     *fzStextStruct
+	FirstBlock *fzStextBlock
+	LastBlock  *fzStextBlock
+	Standard   int32
+}
+
+type fzStextLine struct {
+	Wmode     uint8
+	Flags     uint8
+	_         [2]byte
+	Dir       fzPoint
+	Bbox      fzRect
+	_         [4]byte
+	FirstChar *fzStextChar
+	LastChar  *fzStextChar
+	Prev      *fzStextLine
+	Next      *fzStextLine
+}
+
+type fzStextChar struct {
+	C      int32
+	Bidi   uint16
+	Flags  uint16
+	Argb   uint32
+	Origin fzPoint
+	Quad   fzQuad
+	Size   float32
+	Font   *fzFont
+	Next   *fzStextChar
+}
+
 type fzImage struct {
-	_ [32]byte // fz_key_storable
-	W int32
-	H int32
+	_   [32]byte // fz_key_storable
+	W   int32
+	H   int32
+	N   uint8
+	Bpc uint8
+}
+
+type fzCompressedBuffer struct {
+	Refs   int32
+	_      int32
+	Type   int32 // params.type
+	_      int32
+	U      [32]byte // params.u
+	Buffer *fzBuffer
+}
+
+// jbig2Embedded returns params.u.jbig2.embedded of a compressed buffer.
+func (b *fzCompressedBuffer) jbig2Embedded() int32 {
+	return *(*int32)(unsafe.Pointer(&b.U[8]))
 }
 
 type fzDeviceContainerStack struct {
@@ -1241,3 +3415,6 @@ type fzStore struct{}
 type fzGlyphCache struct{}
 type fzSeparations struct{}
 type fzPool struct{}
+type fzFont struct{}
+type fzBandWriter struct{}
+type fzDisplayList struct{}
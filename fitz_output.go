@@ -0,0 +1,19 @@
+package fitz
+
+import "io"
+
+// outputWriter is the state of an fz_output that writes to an io.Writer.
+type outputWriter struct {
+	w      io.Writer
+	err    error
+	handle uintptr
+}
+
+// write writes p to the underlying writer, keeping the first error since MuPDF can't be told about it.
+func (o *outputWriter) write(p []byte) {
+	if o.err != nil {
+		return
+	}
+
+	_, o.err = o.w.Write(p)
+}
@@ -0,0 +1,323 @@
+package fitz
+
+import (
+	"image"
+	"image/color"
+	"io"
+)
+
+// Colorspace of rendered images.
+type Colorspace int
+
+// Colorspaces.
+const (
+	ColorspaceRGB Colorspace = iota
+	ColorspaceGray
+	ColorspaceBGR
+	ColorspaceCMYK
+)
+
+// BoxType is a page box, as defined for PDF.
+type BoxType int
+
+// Page boxes.
+const (
+	// BoxCrop is the visible area of the page, as returned by Bound.
+	BoxCrop BoxType = iota
+	BoxMedia
+	BoxBleed
+	BoxTrim
+	BoxArt
+)
+
+// fz returns the fz_box_type of the box.
+func (b BoxType) fz() int {
+	switch b {
+	case BoxCrop:
+		return 1
+	case BoxMedia:
+		return 0
+	}
+
+	return int(b)
+}
+
+// AntiAliasNone disables anti-aliasing in RenderOptions.
+const AntiAliasNone = -1
+
+// RenderOptions type.
+type RenderOptions struct {
+	// Resolution in dots per inch, 72 if not set.
+	DPI float64
+	// Colorspace of the image, RGB if not set.
+	Colorspace Colorspace
+	// Transparent leaves the background transparent instead of white, it is not supported for CMYK.
+	Transparent bool
+	// Bits of anti-aliasing for text and for graphics, from 1 to 8, or AntiAliasNone. The default level is kept if not set.
+	TextAntiAlias     int
+	GraphicsAntiAlias int
+	// Rotate clockwise in steps of 90 degrees, other angles are not supported.
+	Rotate int
+	// Matrix replaces DPI and Rotate as the transform from page points to pixels if set.
+	Matrix Matrix
+	// Clip renders only the part of the page inside the rectangle, in page points, if set.
+	Clip Rect
+	// Box is the page box to render, e.g. BoxTrim for print proofs, the crop box if not set.
+	Box BoxType
+}
+
+// validate returns ErrRenderOptions for options that cannot be rendered as asked.
+func (o RenderOptions) validate() error {
+	if o.Matrix == (Matrix{}) && o.Rotate%90 != 0 {
+		return ErrRenderOptions
+	}
+
+	if o.Transparent && o.Colorspace == ColorspaceCMYK {
+		return ErrRenderOptions
+	}
+
+	return nil
+}
+
+// matrix returns the transform from page points to pixels.
+func (o RenderOptions) matrix() Matrix {
+	if o.Matrix != (Matrix{}) {
+		return o.Matrix
+	}
+
+	dpi := o.DPI
+	if dpi <= 0 {
+		dpi = 72
+	}
+
+	z := dpi / 72
+
+	switch ((o.Rotate/90)%4 + 4) % 4 {
+	case 1:
+		return Matrix{B: z, C: -z}
+	case 2:
+		return Matrix{A: -z, D: -z}
+	case 3:
+		return Matrix{B: -z, C: z}
+	}
+
+	return Matrix{A: z, D: z}
+}
+
+// rotated reports whether the page is rotated and must be translated back to the origin.
+func (o RenderOptions) rotated() bool {
+	return o.Matrix == (Matrix{}) && o.Rotate%360 != 0
+}
+
+// aaLevel returns the anti-aliasing bits for level, and false to keep the default.
+func aaLevel(level int) (int, bool) {
+	switch {
+	case level == 0:
+		return 0, false
+	case level < 0:
+		return 0, true
+	}
+
+	return min(level, 8), true
+}
+
+// pixmapImage copies the samples of a pixmap with n components per pixel to an image of the colorspace.
+func pixmapImage(cs Colorspace, n int, rect image.Rectangle, stride int, samples []byte) image.Image {
+	pix := make([]byte, len(samples))
+	copy(pix, samples)
+
+	switch cs {
+	case ColorspaceGray:
+		if n == 1 {
+			return &image.Gray{Pix: pix, Stride: stride, Rect: rect}
+		}
+
+		img := image.NewRGBA(rect)
+		for y := 0; y < rect.Dy(); y++ {
+			for x := 0; x < rect.Dx(); x++ {
+				s := pix[y*stride+x*2:]
+				d := img.Pix[y*img.Stride+x*4:]
+				d[0], d[1], d[2], d[3] = s[0], s[0], s[0], s[1]
+			}
+		}
+
+		return img
+	case ColorspaceBGR:
+		return &BGRA{Pix: pix, Stride: stride, Rect: rect}
+	case ColorspaceCMYK:
+		return &image.CMYK{Pix: pix, Stride: stride, Rect: rect}
+	}
+
+	return &image.RGBA{Pix: pix, Stride: stride, Rect: rect}
+}
+
+// BGRA is an in-memory image whose At method returns color.RGBA values, with premultiplied pixels stored in blue, green, red, alpha order.
+type BGRA struct {
+	Pix    []uint8
+	Stride int
+	Rect   image.Rectangle
+}
+
+// ColorModel returns the color model of the image.
+func (p *BGRA) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+// Bounds returns the domain for which At can return non-zero color.
+func (p *BGRA) Bounds() image.Rectangle {
+	return p.Rect
+}
+
+// At returns the color of the pixel at (x, y).
+func (p *BGRA) At(x, y int) color.Color {
+	if !(image.Point{X: x, Y: y}.In(p.Rect)) {
+		return color.RGBA{}
+	}
+
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+4 : i+4]
+
+	return color.RGBA{R: s[2], G: s[1], B: s[0], A: s[3]}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to the pixel at (x, y).
+func (p *BGRA) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+}
+
+// ImageFormat is an encoded image format.
+type ImageFormat int
+
+// Image formats.
+const (
+	ImageFormatPNG ImageFormat = iota
+	ImageFormatPAM
+	ImageFormatJPEG
+	ImageFormatPNM
+	ImageFormatPSD
+	ImageFormatJPX
+	ImageFormatPKM
+)
+
+// banded reports whether WriteImage can encode format band by band.
+func (format ImageFormat) banded() bool {
+	switch format {
+	case ImageFormatPNG, ImageFormatPAM, ImageFormatPNM, ImageFormatPSD:
+		return true
+	}
+
+	return false
+}
+
+// defaultImageQuality is the JPEG and JPX quality used when none is given.
+const defaultImageQuality = 90
+
+// pixmap returns the colorspace and alpha of the pixmap encoded in format.
+// Only PNG keeps an alpha channel, and PKM is halftoned from CMYK.
+func (format ImageFormat) pixmap() (Colorspace, int) {
+	switch format {
+	case ImageFormatPNG:
+		return ColorspaceRGB, 1
+	case ImageFormatPKM:
+		return ColorspaceCMYK, 0
+	}
+
+	return ColorspaceRGB, 0
+}
+
+// imageQuality returns the encoder quality for q, from 1 to 100, or the default if not set.
+func imageQuality(q int) int {
+	if q <= 0 {
+		return defaultImageQuality
+	}
+
+	return min(q, 100)
+}
+
+// imageBandHeight is the number of rows WriteImage renders at a time.
+const imageBandHeight = 64
+
+// RenderTiles renders the page at the given DPI one tile at a time, replaying its display list, and calls fn with each tile
+// and its position in the whole image, row by row. Tiles on the right and bottom edges may be smaller than tileW x tileH.
+func (p *Page) RenderTiles(dpi float64, tileW, tileH int, fn func(tile image.Image, at image.Point) error) error {
+	if tileW <= 0 || tileH <= 0 {
+		return ErrTileSize
+	}
+
+	bounds, err := p.tileBounds(dpi)
+	if err != nil {
+		return err
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += tileH {
+		for x := bounds.Min.X; x < bounds.Max.X; x += tileW {
+			r := image.Rect(x, y, x+tileW, y+tileH).Intersect(bounds)
+
+			tile, err := p.renderTile(dpi, r)
+			if err != nil {
+				return err
+			}
+
+			if err := fn(tile, r.Min); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// RenderTiles renders given page number at the given DPI one tile at a time, see (*Page).RenderTiles.
+func (f *Document) RenderTiles(pageNumber int, dpi float64, tileW, tileH int, fn func(tile image.Image, at image.Point) error) error {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return err
+	}
+
+	defer page.Close()
+
+	return page.RenderTiles(dpi, tileW, tileH, fn)
+}
+
+// ImagePNG returns image for given page number as PNG bytes.
+func (f *Document) ImagePNG(pageNumber int, dpi float64) ([]byte, error) {
+	return f.ImageEncoded(pageNumber, dpi, ImageFormatPNG, 0)
+}
+
+// ImageEncoded returns image for given page number encoded in format. Quality from 1 to 100 applies to JPEG and JPX,
+// 0 selects the default.
+func (f *Document) ImageEncoded(pageNumber int, dpi float64, format ImageFormat, quality int) ([]byte, error) {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	defer page.Close()
+
+	return page.ImageEncoded(dpi, format, quality)
+}
+
+// WriteImageEncoded writes image for given page number encoded in format to w, see ImageEncoded.
+func (f *Document) WriteImageEncoded(pageNumber int, dpi float64, format ImageFormat, quality int, w io.Writer) error {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return err
+	}
+
+	defer page.Close()
+
+	return page.WriteImageEncoded(dpi, format, quality, w)
+}
+
+// WriteImage writes given page number rendered at the given DPI to w, encoded in format band by band with bounded memory.
+// Only PNG, PAM, PNM and PSD can be written by bands, see WriteImageEncoded for the other formats.
+func (f *Document) WriteImage(pageNumber int, dpi float64, format ImageFormat, w io.Writer) error {
+	page, err := f.LoadPage(pageNumber)
+	if err != nil {
+		return err
+	}
+
+	defer page.Close()
+
+	return page.WriteImage(dpi, format, w)
+}
@@ -0,0 +1,134 @@
+package fitz
+
+import (
+	"context"
+	"regexp/syntax"
+)
+
+// searchMaxHits is the number of quads SearchAll collects per page at first, doubled until all the hits fit.
+const searchMaxHits = 1024
+
+const (
+	fzSearchIgnoreCase       = 1
+	fzSearchIgnoreDiacritics = 2
+	fzSearchRegexp           = 4
+	fzSearchKeepLines        = 8
+	fzSearchKeepParagraphs   = 16
+	fzSearchKeepHyphens      = 32
+)
+
+// SearchOptions type.
+type SearchOptions struct {
+	// IgnoreCase matches regardless of letter case.
+	IgnoreCase bool
+	// IgnoreDiacritics matches regardless of accents and other diacritics.
+	IgnoreDiacritics bool
+	// Regexp treats the needle as a regular expression, in the syntax of MuPDF's regexp engine.
+	// Needles that do not parse as Perl syntax regular expressions return ErrSearch, before MuPDF fails on them.
+	Regexp bool
+	// KeepLines does not match across line breaks.
+	KeepLines bool
+	// KeepParagraphs does not match across paragraph breaks.
+	KeepParagraphs bool
+	// KeepHyphens does not ignore hyphens at the end of lines.
+	KeepHyphens bool
+}
+
+// flags returns the fz_search_options flags for o.
+func (o SearchOptions) flags() int {
+	var flags int
+	if o.IgnoreCase {
+		flags |= fzSearchIgnoreCase
+	}
+	if o.IgnoreDiacritics {
+		flags |= fzSearchIgnoreDiacritics
+	}
+	if o.Regexp {
+		flags |= fzSearchRegexp
+	}
+	if o.KeepLines {
+		flags |= fzSearchKeepLines
+	}
+	if o.KeepParagraphs {
+		flags |= fzSearchKeepParagraphs
+	}
+	if o.KeepHyphens {
+		flags |= fzSearchKeepHyphens
+	}
+
+	return flags
+}
+
+// check returns ErrSearch for a needle that is not a valid regular expression, which MuPDF throws on.
+// Without cgo the throw cannot be caught and exits the process, so both backends check it beforehand.
+func (o SearchOptions) check(needle string) error {
+	if !o.Regexp {
+		return nil
+	}
+
+	if _, err := syntax.Parse(needle, syntax.Perl); err != nil {
+		return ErrSearch
+	}
+
+	return nil
+}
+
+// SearchResult type.
+type SearchResult struct {
+	// Page number of the hits.
+	Page int
+	// Quads of the hits in page points.
+	Quads []Quad
+	// Err is set when the page could not be searched.
+	Err error
+}
+
+// Search returns the hit quads of a case-insensitive search for needle on given page number, at most maxHits.
+// Quads are in page points, scale them by dpi/72 to match images returned by ImageDPI.
+func (f *Document) Search(pageNumber int, needle string, maxHits int) ([]Quad, error) {
+	return f.SearchWithOptions(pageNumber, needle, maxHits, SearchOptions{IgnoreCase: true})
+}
+
+// SearchAll searches every page for needle like Search, without a limit on the hits, streaming pages with hits until done or ctx is cancelled.
+func (f *Document) SearchAll(ctx context.Context, needle string) <-chan SearchResult {
+	results := make(chan SearchResult)
+
+	f.mtx.Lock()
+	pages := f.NumPage()
+	f.mtx.Unlock()
+
+	go func() {
+		defer close(results)
+
+		for n := 0; n < pages; n++ {
+			if ctx.Err() != nil {
+				return
+			}
+
+			quads, err := f.searchAll(n, needle)
+			if err == nil && len(quads) == 0 {
+				continue
+			}
+
+			select {
+			case results <- SearchResult{Page: n, Quads: quads, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return results
+}
+
+// searchAll returns all the hit quads of Search on given page number, extracting the page text once.
+func (f *Document) searchAll(pageNumber int, needle string) ([]Quad, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if needle == "" {
+		return nil, nil
+	}
+
+	return f.search(pageNumber, needle, searchMaxHits, SearchOptions{IgnoreCase: true}, true)
+}
@@ -0,0 +1,38 @@
+package fitz
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// Table is a table found on a page, as rows of cells.
+type Table struct {
+	BBox Rect
+	Rows [][]TableCell
+}
+
+// TableCell is a single cell of a table.
+type TableCell struct {
+	BBox Rect
+	Text string
+}
+
+// WriteCSV writes the table to w as CSV, one record per row.
+func (t Table) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	for _, row := range t.Rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			record[i] = cell.Text
+		}
+
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
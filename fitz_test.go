@@ -1,13 +1,19 @@
 package fitz_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"image"
+	"image/draw"
 	"image/jpeg"
+	"image/png"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 
@@ -89,29 +95,979 @@ func TestImageFromMemory(t *testing.T) {
 	}
 }
 
+func TestLoadPage(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	page, err := doc.LoadPage(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bound, err := page.Bound()
+	if err != nil {
+		t.Error(err)
+	}
+
+	img, err := page.Render(72)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if img.Bounds() != bound {
+		t.Errorf("expected image bounds %v, got %v", bound, img.Bounds())
+	}
+
+	text, err := page.Text()
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected, err := doc.Text(2)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if text != expected {
+		t.Error("page text differs from document text")
+	}
+
+	links, err := page.Links()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(links) != 1 {
+		t.Error("expected 1 link, got", len(links))
+	}
+
+	page.Close()
+
+	if _, err := page.Text(); !errors.Is(err, fitz.ErrPageClosed) {
+		t.Errorf("Expected ErrPageClosed, got %v", err)
+	}
+
+	if _, err := doc.LoadPage(doc.NumPage()); !errors.Is(err, fitz.ErrPageMissing) {
+		t.Errorf("Expected ErrPageMissing, got %v", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	bound, err := doc.Bound(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := doc.Render(0, fitz.RenderOptions{Colorspace: fitz.ColorspaceGray})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := img.(*image.Gray); !ok {
+		t.Errorf("expected *image.Gray, got %T", img)
+	}
+
+	if img.Bounds() != bound {
+		t.Errorf("expected bounds %v, got %v", bound, img.Bounds())
+	}
+
+	img, err = doc.Render(0, fitz.RenderOptions{Colorspace: fitz.ColorspaceCMYK, TextAntiAlias: fitz.AntiAliasNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := img.(*image.CMYK); !ok {
+		t.Errorf("expected *image.CMYK, got %T", img)
+	}
+
+	img, err = doc.Render(0, fitz.RenderOptions{Colorspace: fitz.ColorspaceBGR})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := img.(*fitz.BGRA); !ok {
+		t.Errorf("expected *fitz.BGRA, got %T", img)
+	}
+
+	img, err = doc.Render(0, fitz.RenderOptions{Rotate: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Bounds().Dx() != bound.Dy() || img.Bounds().Dy() != bound.Dx() {
+		t.Errorf("expected rotated bounds, got %v", img.Bounds())
+	}
+
+	for _, opts := range []fitz.RenderOptions{{Rotate: 45}, {Colorspace: fitz.ColorspaceCMYK, Transparent: true}} {
+		if _, err := doc.Render(0, opts); !errors.Is(err, fitz.ErrRenderOptions) {
+			t.Errorf("expected ErrRenderOptions for %+v, got %v", opts, err)
+		}
+	}
+
+	img, err = doc.Render(0, fitz.RenderOptions{Transparent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+		t.Errorf("expected transparent corner, got alpha %d", a)
+	}
+
+	img, err = doc.Render(0, fitz.RenderOptions{DPI: 144, Clip: fitz.Rect{X0: 100, Y0: 100, X1: 200, Y1: 150}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Bounds() != image.Rect(200, 200, 400, 300) {
+		t.Errorf("expected clipped bounds, got %v", img.Bounds())
+	}
+}
+
+func TestRenderInto(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	expected, err := doc.ImageDPI(0, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := image.NewRGBA(expected.Bounds())
+	if err := doc.RenderInto(0, dst, 50); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(dst.Pix, expected.Pix) {
+		t.Error("RenderInto differs from ImageDPI")
+	}
+
+	if err := doc.RenderInto(0, image.NewRGBA(image.Rect(0, 0, 10, 10)), 50); !errors.Is(err, fitz.ErrImageSize) {
+		t.Errorf("Expected ErrImageSize, got %v", err)
+	}
+}
+
+func TestRenderTiles(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	expected, err := doc.ImageDPI(0, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := image.NewRGBA(expected.Bounds())
+
+	var tiles int
+	err = doc.RenderTiles(0, 50, 100, 64, func(tile image.Image, at image.Point) error {
+		if tile.Bounds().Dx() > 100 || tile.Bounds().Dy() > 64 {
+			t.Errorf("tile %v larger than requested", tile.Bounds())
+		}
+
+		draw.Draw(img, tile.Bounds(), tile, at, draw.Src)
+		tiles++
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tiles < 2 {
+		t.Error("expected several tiles, got", tiles)
+	}
+
+	if !bytes.Equal(img.Pix, expected.Pix) {
+		t.Error("tiles differ from ImageDPI")
+	}
+
+	if err := doc.RenderTiles(0, 50, 0, 64, nil); !errors.Is(err, fitz.ErrTileSize) {
+		t.Errorf("Expected ErrTileSize, got %v", err)
+	}
+}
+
+func TestWriteImage(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	var buf bytes.Buffer
+	if err := doc.WriteImage(0, 150, fitz.ImageFormatPNG, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Bounds().Dx() != 1275 || img.Bounds().Dy() != 1650 {
+		t.Errorf("unexpected PNG size %v", img.Bounds())
+	}
+
+	buf.Reset()
+	if err := doc.WriteImage(0, 50, fitz.ImageFormatPAM, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(buf.Bytes(), []byte("P7")) {
+		t.Error("expected PAM header")
+	}
+}
+
+func TestImageEncoded(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	b, err := doc.ImageEncoded(0, 50, fitz.ImageFormatJPEG, 75)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Bounds().Dx() != 425 || img.Bounds().Dy() != 550 {
+		t.Errorf("unexpected JPEG size %v", img.Bounds())
+	}
+
+	var buf bytes.Buffer
+	if err := doc.WriteImageEncoded(0, 50, fitz.ImageFormatPNM, 0, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(buf.Bytes(), []byte("P6")) {
+		t.Error("expected PNM header")
+	}
+
+	if err := doc.WriteImage(0, 50, fitz.ImageFormatJPEG, io.Discard); !errors.Is(err, fitz.ErrImageFormat) {
+		t.Errorf("expected ErrImageFormat, got %v", err)
+	}
+}
+
+func TestImages(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	images, err := doc.Images(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(images) != 1 {
+		t.Fatalf("expected 1 image, got %d", len(images))
+	}
+
+	img := images[0]
+	if img.Format != "jpeg" || img.Width <= 0 || img.Height <= 0 || img.BPC != 8 {
+		t.Errorf("unexpected image %+v", img)
+	}
+
+	data, err := img.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		t.Error("expected original JPEG bytes")
+	}
+}
+
+func TestImagesDecoded(t *testing.T) {
+	// The pages of TIFF documents are decoded to pixmaps, without compressed bytes to return.
+	doc, err := fitz.New(filepath.Join("testdata", "test.tif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	images, err := doc.Images(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(images) != 1 {
+		t.Fatalf("expected 1 image, got %d", len(images))
+	}
+
+	if images[0].Format != "png" {
+		t.Errorf("expected png format, got %q", images[0].Format)
+	}
+
+	data, err := images[0].Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(data, []byte("\x89PNG")) {
+		t.Error("expected image encoded as PNG")
+	}
+}
+
+func TestLayout(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.epub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	if !doc.IsReflowable() {
+		t.Fatal("expected reflowable document")
+	}
+
+	if err := doc.Layout(0, 600, 12); !errors.Is(err, fitz.ErrLayout) {
+		t.Errorf("expected ErrLayout for zero width, got %v", err)
+	}
+
+	if err := doc.Layout(400, 600, 12); err != nil {
+		t.Fatal(err)
+	}
+
+	pages := doc.NumPage()
+	last := pages - 1
+
+	mark, err := doc.MakeBookmark(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc.SetUserCSS("body { font-size: 2em; }")
+
+	if err := doc.Layout(200, 300, 12); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.NumPage() < pages {
+		t.Errorf("expected more pages after smaller layout, got %d < %d", doc.NumPage(), pages)
+	}
+
+	n, err := doc.LookupBookmark(mark)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n < last || n >= doc.NumPage() {
+		t.Errorf("unexpected bookmark page %d", n)
+	}
+
+	pdf, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer pdf.Close()
+
+	if err := pdf.Layout(400, 600, 12); !errors.Is(err, fitz.ErrLayout) {
+		t.Errorf("expected ErrLayout for fixed layout document, got %v", err)
+	}
+}
+
+func TestLocation(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.epub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	if doc.CountChapters() < 1 {
+		t.Fatal("expected chapters")
+	}
+
+	n, err := doc.CountChapterPages(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n < 1 {
+		t.Fatal("expected pages in first chapter")
+	}
+
+	last := doc.NumPage() - 1
+
+	loc, err := doc.LocationFromPage(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pageNumber, err := doc.PageFromLocation(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pageNumber != last {
+		t.Errorf("expected page %d, got %d", last, pageNumber)
+	}
+
+	next, err := doc.NextLocation(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next != loc {
+		t.Errorf("expected last location %v, got %v", loc, next)
+	}
+
+	if last > 0 {
+		prev, err := doc.PreviousLocation(loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if prev == loc {
+			t.Error("expected previous location")
+		}
+	}
+
+	page, err := doc.LoadChapterPage(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer page.Close()
+
+	if page.Number() != last {
+		t.Errorf("expected page number %d, got %d", last, page.Number())
+	}
+
+	if _, err := doc.LoadChapterPage(fitz.Location{Chapter: doc.CountChapters()}); !errors.Is(err, fitz.ErrPageMissing) {
+		t.Errorf("expected ErrPageMissing, got %v", err)
+	}
+}
+
+func TestPageLabel(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "labels.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	for n, want := range []string{"i", "ii", "iii", "1", "2"} {
+		label, err := doc.PageLabel(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if label != want {
+			t.Errorf("page %d label %q, want %q", n, label, want)
+		}
+
+		number, err := doc.PageByLabel(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if number != n {
+			t.Errorf("page by label %q is %d, want %d", want, number, n)
+		}
+	}
+
+	if _, err := doc.PageByLabel("no such label"); !errors.Is(err, fitz.ErrPageMissing) {
+		t.Errorf("expected ErrPageMissing, got %v", err)
+	}
+
+	toc, err := doc.ToC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(toc) != 2 || toc[0].Label != "" {
+		t.Errorf("expected 2 entries without labels, got %+v", toc)
+	}
+
+	toc, err = doc.ToCWithLabels()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(toc) != 2 || toc[0].Label != "ii" || toc[1].Label != "1" {
+		t.Errorf("expected labels ii and 1, got %+v", toc)
+	}
+
+	// Without page labels, the label is the page number counting from 1.
+	plain, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer plain.Close()
+
+	label, err := plain.PageLabel(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if label != "1" {
+		t.Errorf("expected label 1, got %q", label)
+	}
+}
+
+func TestPageBox(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	media, err := doc.PageBox(0, fitz.BoxMedia)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if media != (fitz.Rect{X1: 612, Y1: 792}) {
+		t.Errorf("unexpected media box %v", media)
+	}
+
+	trim, err := doc.PageBox(0, fitz.BoxTrim)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := doc.Render(0, fitz.RenderOptions{DPI: 72, Box: fitz.BoxTrim})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Bounds().Dx() != int(trim.X1-trim.X0) || img.Bounds().Dy() != int(trim.Y1-trim.Y0) {
+		t.Errorf("unexpected render size %v for trim box %v", img.Bounds(), trim)
+	}
+}
+
+func TestRenderContext(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	ctx := context.Background()
+	progress := func(progress, max int) {}
+
+	img, err := doc.ImageDPIContext(ctx, 0, 50, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Bounds().Empty() {
+		t.Error("expected image")
+	}
+
+	text, err := doc.TextContext(ctx, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if text == "" {
+		t.Error("expected text")
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := doc.ImageDPIContext(canceled, 0, 50, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	if _, err := doc.TextContext(canceled, 0, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestDisplayList(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	doc.SetDisplayListCache(0)
+
+	expected, err := doc.ImageDPI(0, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc.SetDisplayListCache(2)
+
+	page, err := doc.LoadPage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer page.Close()
+
+	if err := page.DisplayList(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dpi := range []float64{50, 100, 50} {
+		img, err := page.Render(dpi)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if dpi == 50 && !bytes.Equal(img.Pix, expected.Pix) {
+			t.Errorf("replayed render at %v DPI differs", dpi)
+		}
+	}
+
+	text, err := doc.Text(0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if text == "" {
+		t.Error("expected text from cached display list")
+	}
+}
+
 func TestLinks(t *testing.T) {
 	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
 	if err != nil {
 		t.Error(err)
 	}
 
-	defer doc.Close()
-
-	links, err := doc.Links(2)
-	if err != nil {
-		t.Error(err)
+	defer doc.Close()
+
+	links, err := doc.Links(2)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(links) != 1 {
+		t.Error("expected 1 link, got", len(links))
+	}
+
+	if links[0].URI != "https://creativecommons.org/licenses/by-nc-sa/4.0/" {
+		t.Error("expected empty URI, got", links[0].URI)
+	}
+
+	if !links[0].External {
+		t.Error("expected external link")
+	}
+
+	if links[0].Dest.Page != -1 {
+		t.Error("expected no internal destination, got page", links[0].Dest.Page)
+	}
+
+	if r := links[0].Rect; r.X1 <= r.X0 || r.Y1 <= r.Y0 {
+		t.Error("expected link rectangle, got", r)
+	}
+}
+
+func TestText(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	defer doc.Close()
+
+	tmpDir, err := os.MkdirTemp(os.TempDir(), "fitz")
+	if err != nil {
+		t.Error(err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	for n := 0; n < doc.NumPage(); n++ {
+		text, err := doc.Text(n)
+		if err != nil {
+			t.Error(err)
+		}
+
+		f, err := os.Create(filepath.Join(tmpDir, fmt.Sprintf("test%03d.txt", n)))
+		if err != nil {
+			t.Error(err)
+		}
+
+		_, err = f.WriteString(text)
+		if err != nil {
+			t.Error(err)
+		}
+
+		f.Close()
+	}
+}
+
+func TestTextWithOptions(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	defer doc.Close()
+
+	text, err := doc.TextWithOptions(0, fitz.TextOptions{Dehyphenate: true, Clip: true})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if text == "" {
+		t.Error("expected text")
+	}
+
+	text, err = doc.TextWithOptions(0, fitz.TextOptions{Options: "dehyphenate,preserve-whitespace"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if text == "" {
+		t.Error("expected text")
+	}
+
+	for _, options := range []string{"no-such-option", "dehyphenate=maybe", "resolution=high", "dehyphenate,"} {
+		if _, err := doc.TextWithOptions(0, fitz.TextOptions{Options: options}); !errors.Is(err, fitz.ErrTextOptions) {
+			t.Errorf("expected ErrTextOptions for %q, got %v", options, err)
+		}
+	}
+
+	html, err := doc.HTMLWithOptions(0, false, fitz.TextOptions{PreserveLigatures: true})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !strings.Contains(html, "<div") {
+		t.Errorf("unexpected html %q", html)
+	}
+}
+
+func TestStructuredText(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	defer doc.Close()
+
+	text, err := doc.Text(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp, err := doc.StructuredText(0, fitz.TextOptions{PreserveImages: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tp.Mediabox != (fitz.Rect{X0: 0, Y0: 0, X1: 612, Y1: 792}) {
+		t.Errorf("unexpected mediabox %v", tp.Mediabox)
+	}
+
+	var lines int
+	for _, block := range tp.Blocks {
+		if block.Type != fitz.TextBlockText {
+			continue
+		}
+
+		for _, line := range block.Lines {
+			lines++
+
+			if !strings.Contains(text, line.String()) {
+				t.Errorf("line %q not found in page text", line.String())
+			}
+
+			for _, span := range line.Spans {
+				if span.Font == "" || span.Size <= 0 {
+					t.Errorf("unexpected span style %q %v", span.Font, span.Size)
+				}
+
+				for _, c := range span.Chars {
+					if c.Origin.X < tp.Mediabox.X0 || c.Origin.X > tp.Mediabox.X1 {
+						t.Errorf("char %q origin %v outside of page", c.Rune, c.Origin)
+					}
+				}
+			}
+		}
+	}
+
+	if lines == 0 {
+		t.Error("expected text lines")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	defer doc.Close()
+
+	text, err := doc.Text(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		t.Fatal("expected text on first page")
+	}
+
+	quads, err := doc.Search(0, words[0], 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(quads) == 0 {
+		t.Fatalf("expected hits for %q", words[0])
+	}
+
+	for _, q := range quads {
+		if q.UL.X < 0 || q.LR.X > 612 || q.UL.Y < 0 || q.LR.Y > 792 {
+			t.Errorf("hit %v outside of page", q)
+		}
+	}
+
+	_, err = doc.SearchWithOptions(0, "(", 16, fitz.SearchOptions{Regexp: true})
+	if !errors.Is(err, fitz.ErrSearch) {
+		t.Errorf("Expected ErrSearch, got %v", err)
+	}
+
+	var pages int
+	for res := range doc.SearchAll(context.Background(), words[0]) {
+		if res.Err != nil {
+			t.Error(res.Err)
+		}
+		pages++
+	}
+
+	if pages == 0 {
+		t.Error("expected hits from SearchAll")
+	}
+}
+
+func TestSelectText(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "table.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	text, err := doc.TextInRect(0, fitz.Rect{X0: 72, Y0: 118, X1: 252, Y1: 138})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.TrimSpace(text) != "Apples" {
+		t.Errorf("TextInRect: expected %q, got %q", "Apples", text)
+	}
+
+	text, quads, err := doc.SelectText(0, fitz.Point{X: 70, Y: 128}, fitz.Point{X: 500, Y: 128})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(text, "Apples") || !strings.Contains(text, "1.20") {
+		t.Errorf("SelectText: unexpected text %q", text)
+	}
+
+	if len(quads) == 0 {
+		t.Error("SelectText: expected highlight quads")
+	}
+}
+
+func TestParagraphs(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	defer doc.Close()
+
+	paragraphs, err := doc.Paragraphs(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(paragraphs) == 0 {
+		t.Fatal("expected paragraphs")
+	}
+
+	for _, p := range paragraphs {
+		if p.BBox.X0 < p.RegionBBox.X0-1 || p.BBox.X1 > p.RegionBBox.X1+1 {
+			t.Errorf("paragraph %v outside of region %d %v", p.BBox, p.Region, p.RegionBBox)
+		}
+	}
+}
+
+func TestTables(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "table.pdf"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	defer doc.Close()
+
+	tables, err := doc.Tables(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tables) != 1 {
+		t.Fatalf("expected 1 table, got %d", len(tables))
+	}
+
+	var found bool
+	for _, row := range tables[0].Rows {
+		if len(row) == 3 && strings.TrimSpace(row[0].Text) == "Apples" && strings.TrimSpace(row[2].Text) == "1.20" {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Errorf("expected row Apples, 3, 1.20 in %v", tables[0].Rows)
 	}
 
-	if len(links) != 1 {
-		t.Error("expected 1 link, got", len(links))
+	var buf bytes.Buffer
+	if err := tables[0].WriteCSV(&buf); err != nil {
+		t.Error(err)
 	}
 
-	if links[0].URI != "https://creativecommons.org/licenses/by-nc-sa/4.0/" {
-		t.Error("expected empty URI, got", links[0].URI)
+	if !strings.Contains(buf.String(), "Pears") {
+		t.Errorf("unexpected csv %q", buf.String())
 	}
 }
 
-func TestText(t *testing.T) {
+func TestHTML(t *testing.T) {
 	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
 	if err != nil {
 		t.Error(err)
@@ -127,17 +1083,17 @@ func TestText(t *testing.T) {
 	defer os.RemoveAll(tmpDir)
 
 	for n := 0; n < doc.NumPage(); n++ {
-		text, err := doc.Text(n)
+		html, err := doc.HTML(n, true)
 		if err != nil {
 			t.Error(err)
 		}
 
-		f, err := os.Create(filepath.Join(tmpDir, fmt.Sprintf("test%03d.txt", n)))
+		f, err := os.Create(filepath.Join(tmpDir, fmt.Sprintf("test%03d.html", n)))
 		if err != nil {
 			t.Error(err)
 		}
 
-		_, err = f.WriteString(text)
+		_, err = f.WriteString(html)
 		if err != nil {
 			t.Error(err)
 		}
@@ -146,7 +1102,7 @@ func TestText(t *testing.T) {
 	}
 }
 
-func TestHTML(t *testing.T) {
+func TestTextAs(t *testing.T) {
 	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
 	if err != nil {
 		t.Error(err)
@@ -154,30 +1110,27 @@ func TestHTML(t *testing.T) {
 
 	defer doc.Close()
 
-	tmpDir, err := os.MkdirTemp(os.TempDir(), "fitz")
-	if err != nil {
-		t.Error(err)
+	formats := map[fitz.TextFormat]string{
+		fitz.TextFormatText:  "",
+		fitz.TextFormatHTML:  "<html>",
+		fitz.TextFormatXHTML: "<html",
+		fitz.TextFormatXML:   "<page",
+		fitz.TextFormatJSON:  "{",
 	}
 
-	defer os.RemoveAll(tmpDir)
-
-	for n := 0; n < doc.NumPage(); n++ {
-		html, err := doc.HTML(n, true)
-		if err != nil {
+	for format, prefix := range formats {
+		var buf bytes.Buffer
+		if err := doc.TextAs(0, format, &buf); err != nil {
 			t.Error(err)
 		}
 
-		f, err := os.Create(filepath.Join(tmpDir, fmt.Sprintf("test%03d.html", n)))
-		if err != nil {
-			t.Error(err)
+		if buf.Len() == 0 || !strings.Contains(buf.String(), prefix) {
+			t.Errorf("unexpected output for format %d: %.80q", format, buf.String())
 		}
 
-		_, err = f.WriteString(html)
-		if err != nil {
-			t.Error(err)
+		if format == fitz.TextFormatJSON && !json.Valid(buf.Bytes()) {
+			t.Errorf("invalid json %.80q", buf.String())
 		}
-
-		f.Close()
 	}
 }
 
@@ -269,6 +1222,12 @@ func TestMetadata(t *testing.T) {
 	if len(meta) == 0 {
 		t.Error(fmt.Errorf("metadata is empty"))
 	}
+
+	for key, value := range meta {
+		if strings.ContainsRune(value, 0) {
+			t.Errorf("metadata %s is not trimmed at NUL: %q", key, value)
+		}
+	}
 }
 
 func TestBound(t *testing.T) {
@@ -296,6 +1255,130 @@ func TestBound(t *testing.T) {
 	}
 }
 
+func TestGeometry(t *testing.T) {
+	r := fitz.Rect{X1: 612, Y1: 792}
+
+	if got := r.ToImageRect(150); got != image.Rect(0, 0, 1275, 1650) {
+		t.Errorf("unexpected image rect %v", got)
+	}
+
+	if got := r.Transform(fitz.Rotate(90)); got != (fitz.Rect{X0: -792, X1: 0, Y1: 612}) {
+		t.Errorf("unexpected rotated rect %v", got)
+	}
+
+	if got := (fitz.Point{X: 1, Y: 0}).Transform(fitz.Rotate(90)); got != (fitz.Point{X: 0, Y: 1}) {
+		t.Errorf("unexpected rotated point %v", got)
+	}
+
+	s := fitz.Rect{X0: 100, Y0: 100, X1: 700, Y1: 200}
+
+	if got := r.Intersect(s); got != (fitz.Rect{X0: 100, Y0: 100, X1: 612, Y1: 200}) {
+		t.Errorf("unexpected intersection %v", got)
+	}
+
+	if got := r.Union(s); got != (fitz.Rect{X1: 700, Y1: 792}) {
+		t.Errorf("unexpected union %v", got)
+	}
+
+	if got := r.Intersect(fitz.InfiniteRect); got != r {
+		t.Errorf("unexpected intersection with infinite rect %v", got)
+	}
+
+	m := fitz.Scale(2, 3).Concat(fitz.Translate(10, 20))
+	if got := m.Concat(m.Invert()); got != fitz.Identity {
+		t.Errorf("unexpected inverse %v", got)
+	}
+
+	q := fitz.Quad{UL: fitz.Point{X: 1, Y: 1}, UR: fitz.Point{X: 3, Y: 1}, LL: fitz.Point{X: 1, Y: 2}, LR: fitz.Point{X: 3, Y: 2}}
+	if got := q.Transform(m).Rect(); got != (fitz.Rect{X0: 12, Y0: 23, X1: 16, Y1: 26}) {
+		t.Errorf("unexpected quad bounds %v", got)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "encrypted.pdf"))
+	if !errors.Is(err, fitz.ErrNeedsPassword) {
+		t.Fatalf("Expected ErrNeedsPassword, got %v", err)
+	}
+
+	defer doc.Close()
+
+	if _, err = doc.Authenticate("wrong"); !errors.Is(err, fitz.ErrWrongPassword) {
+		t.Errorf("Expected ErrWrongPassword, got %v", err)
+	}
+
+	res, err := doc.Authenticate("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res&fitz.AuthUser == 0 {
+		t.Errorf("expected user password to match, got %v", res)
+	}
+
+	text, err := doc.Text(0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !strings.Contains(text, "Hello, encrypted world") {
+		t.Errorf("unexpected text %q", text)
+	}
+}
+
+func TestNewWithPassword(t *testing.T) {
+	doc, err := fitz.NewWithPassword(filepath.Join("testdata", "encrypted.pdf"), "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	if doc.NumPage() != 1 {
+		t.Errorf("expected 1 page, got %d", doc.NumPage())
+	}
+
+	b, err := os.ReadFile(filepath.Join("testdata", "encrypted.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fitz.NewFromMemoryWithPassword(b, "wrong")
+	if !errors.Is(err, fitz.ErrWrongPassword) {
+		t.Errorf("Expected ErrWrongPassword, got %v", err)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	doc, err := fitz.NewWithPassword(filepath.Join("testdata", "encrypted.pdf"), "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	if !doc.HasPermission(fitz.PermissionPrint) {
+		t.Error("expected print permission")
+	}
+
+	if doc.HasPermission(fitz.PermissionCopy) {
+		t.Error("expected copy to be denied")
+	}
+
+	info := doc.Info()
+	if !info.Encrypted {
+		t.Errorf("expected encrypted document, got encryption %q", info.Encryption)
+	}
+
+	if info.Permissions&fitz.PermissionCopy != 0 {
+		t.Errorf("unexpected permissions %b", info.Permissions)
+	}
+
+	if info.Title != "Encrypted" {
+		t.Errorf("expected title Encrypted, got %q", info.Title)
+	}
+}
+
 func TestEmptyBytes(t *testing.T) {
 	var err error
 	// empty reader
@@ -362,3 +1445,237 @@ func TestConcurrent(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func BenchmarkImageDPI(b *testing.B) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := doc.ImageDPI(i%doc.NumPage(), 72); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRenderInto(b *testing.B) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	dst := make([]*image.RGBA, doc.NumPage())
+	for n := range dst {
+		bound, err := doc.Bound(n)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		dst[n] = image.NewRGBA(bound)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		n := i % doc.NumPage()
+		if err := doc.RenderInto(n, dst[n], 72); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	var pages int
+	progress := func(progress, max int) {
+		pages = progress
+	}
+
+	var buf bytes.Buffer
+	err = fitz.ConvertContext(context.Background(), doc, &buf, "pdf", "", nil, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF")) {
+		t.Errorf("unexpected pdf header %q", buf.Bytes()[:min(buf.Len(), 8)])
+	}
+
+	pdf, err := fitz.NewFromMemory(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer pdf.Close()
+
+	if pages != doc.NumPage() {
+		t.Errorf("progress reported %d pages, want %d", pages, doc.NumPage())
+	}
+
+	if pdf.NumPage() != doc.NumPage() {
+		t.Errorf("converted %d pages, want %d", pdf.NumPage(), doc.NumPage())
+	}
+
+	buf.Reset()
+	err = fitz.Convert(doc, &buf, "txt", "", []int{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.Len() == 0 {
+		t.Error("empty text conversion")
+	}
+
+	if err := fitz.Convert(doc, io.Discard, "xyz", "", nil); !errors.Is(err, fitz.ErrDocumentFormat) {
+		t.Errorf("unexpected error %v for unknown format", err)
+	}
+
+	if err := fitz.Convert(doc, io.Discard, "pdf", "", []int{doc.NumPage()}); !errors.Is(err, fitz.ErrPageMissing) {
+		t.Errorf("unexpected error %v for missing page", err)
+	}
+}
+
+func TestDocumentWriter(t *testing.T) {
+	doc, err := fitz.New(filepath.Join("testdata", "test.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer doc.Close()
+
+	xps, err := fitz.New(filepath.Join("testdata", "test.xps"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer xps.Close()
+
+	var buf bytes.Buffer
+	w, err := fitz.NewDocumentWriter(doc, &buf, "pdf", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := doc.LoadPage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer page.Close()
+
+	bounds, err := page.Bound()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two pages side by side on one sheet.
+	sheet := fitz.Rect{X1: 2 * float64(bounds.Dx()), Y1: float64(bounds.Dy())}
+	dev := w.BeginPage(sheet)
+	if dev == nil {
+		t.Fatal(w.EndPage())
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := page.Run(dev, fitz.Translate(float64(i*bounds.Dx()), 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.EndPage(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := page.Run(dev, fitz.Identity); !errors.Is(err, fitz.ErrDeviceClosed) {
+		t.Errorf("unexpected error %v after EndPage", err)
+	}
+
+	other, err := xps.LoadPage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer other.Close()
+
+	otherBounds, err := other.Bound()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A page of each document on one sheet, then the page of the other document alone.
+	dev = w.BeginPage(sheet)
+	if dev == nil {
+		t.Fatal(w.EndPage())
+	}
+
+	if err := page.Run(dev, fitz.Identity); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := other.Run(dev, fitz.Translate(float64(bounds.Dx()), 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.EndPage(); err != nil {
+		t.Fatal(err)
+	}
+
+	dev = w.BeginPage(fitz.Rect{X1: float64(otherBounds.Dx()), Y1: float64(otherBounds.Dy())})
+	if dev == nil {
+		t.Fatal(w.EndPage())
+	}
+
+	if err := other.Run(dev, fitz.Identity); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.EndPage(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := fitz.NewFromMemory(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer out.Close()
+
+	if out.NumPage() != 3 {
+		t.Fatalf("wrote %d pages, want 3", out.NumPage())
+	}
+
+	b, err := out.Bound(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Dx() != 2*bounds.Dx() {
+		t.Errorf("unexpected sheet width %d, want %d", b.Dx(), 2*bounds.Dx())
+	}
+
+	b, err = out.Bound(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Dx() != otherBounds.Dx() || b.Dy() != otherBounds.Dy() {
+		t.Errorf("unexpected page size %dx%d, want %dx%d", b.Dx(), b.Dy(), otherBounds.Dx(), otherBounds.Dy())
+	}
+}
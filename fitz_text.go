@@ -0,0 +1,271 @@
+package fitz
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	fzStextPreserveLigatures  = 1
+	fzStextPreserveWhitespace = 2
+	fzStextPreserveImages     = 4
+	fzStextInhibitSpaces      = 8
+	fzStextDehyphenate        = 16
+	fzStextPreserveSpans      = 32
+	fzStextClip               = 64
+	fzStextAccurateBboxes     = 512
+	fzStextCollectVectors     = 1024
+	fzStextIgnoreActualText   = 2048
+	fzStextSegment            = 4096
+	fzStextParagraphBreak     = 8192
+	fzStextTableHunt          = 16384
+
+	fzStextLineFlagsJoined = 1
+
+	fzSelectChars = 0
+
+	fzStextBlockText   = 0
+	fzStextBlockImage  = 1
+	fzStextBlockStruct = 2
+)
+
+// selectMaxQuads is the maximum number of quads SelectText returns.
+const selectMaxQuads = 1024
+
+// TextOptions type.
+type TextOptions struct {
+	// PreserveLigatures keeps ligatures as single characters instead of expanding them.
+	PreserveLigatures bool
+	// PreserveWhitespace keeps whitespace as is instead of converting it to spaces.
+	PreserveWhitespace bool
+	// PreserveImages keeps images as image blocks.
+	PreserveImages bool
+	// InhibitSpaces does not add spaces between gaps in the text.
+	InhibitSpaces bool
+	// Dehyphenate joins words hyphenated at the end of a line.
+	Dehyphenate bool
+	// PreserveSpans does not merge spans on the same line.
+	PreserveSpans bool
+	// Clip drops characters that are entirely outside the page mediabox.
+	Clip bool
+	// IgnoreActualText uses the drawn glyphs instead of ActualText replacements.
+	IgnoreActualText bool
+	// AccurateBboxes computes character bboxes from the glyph outlines instead of the font metrics.
+	AccurateBboxes bool
+
+	// Options in MuPDF's comma separated syntax, e.g. "dehyphenate,preserve-whitespace", combined with the fields above.
+	// The options are preserve-ligatures, preserve-whitespace, preserve-images, inhibit-spaces, dehyphenate, preserve-spans,
+	// clip, accurate-bboxes, vectors, ignore-actualtext, segment, paragraph-break and table-hunt, set to yes or no,
+	// and resolution in DPI. Other options or values return ErrTextOptions.
+	Options string
+}
+
+// textOptionKeys are the keys of TextOptions.Options with a boolean value.
+var textOptionKeys = map[string]bool{
+	"preserve-ligatures":  true,
+	"preserve-whitespace": true,
+	"preserve-images":     true,
+	"inhibit-spaces":      true,
+	"dehyphenate":         true,
+	"preserve-spans":      true,
+	"clip":                true,
+	"accurate-bboxes":     true,
+	"vectors":             true,
+	"ignore-actualtext":   true,
+	"segment":             true,
+	"paragraph-break":     true,
+	"table-hunt":          true,
+}
+
+// check returns ErrTextOptions if Options is not made of known options with valid values. MuPDF throws on the others,
+// which cannot be caught without cgo, so both backends check the options before parsing them.
+func (o TextOptions) check() error {
+	if o.Options == "" {
+		return nil
+	}
+
+	for _, opt := range strings.Split(o.Options, ",") {
+		key, val, _ := strings.Cut(opt, "=")
+
+		switch {
+		case textOptionKeys[key]:
+			switch val {
+			case "", "1", "yes", "true", "enabled", "0", "no", "false", "disabled":
+			default:
+				return ErrTextOptions
+			}
+		case key == "resolution":
+			if _, err := strconv.ParseFloat(val, 32); err != nil {
+				return ErrTextOptions
+			}
+		default:
+			return ErrTextOptions
+		}
+	}
+
+	return nil
+}
+
+// flags returns the fz_stext_options flags for o.
+func (o TextOptions) flags() int {
+	var flags int
+	if o.PreserveLigatures {
+		flags |= fzStextPreserveLigatures
+	}
+	if o.PreserveWhitespace {
+		flags |= fzStextPreserveWhitespace
+	}
+	if o.PreserveImages {
+		flags |= fzStextPreserveImages
+	}
+	if o.InhibitSpaces {
+		flags |= fzStextInhibitSpaces
+	}
+	if o.Dehyphenate {
+		flags |= fzStextDehyphenate
+	}
+	if o.PreserveSpans {
+		flags |= fzStextPreserveSpans
+	}
+	if o.Clip {
+		flags |= fzStextClip
+	}
+	if o.IgnoreActualText {
+		flags |= fzStextIgnoreActualText
+	}
+	if o.AccurateBboxes {
+		flags |= fzStextAccurateBboxes
+	}
+
+	return flags
+}
+
+// TextFormat is an output format for the text of a page.
+type TextFormat int
+
+// Text formats.
+const (
+	TextFormatText TextFormat = iota
+	TextFormatHTML
+	TextFormatXHTML
+	TextFormatXML
+	// TextFormatJSON gives the coordinates in page points, at scale 1.
+	TextFormatJSON
+)
+
+// textOptions returns the options used to extract text for format.
+func (t TextFormat) textOptions() TextOptions {
+	switch t {
+	case TextFormatHTML, TextFormatXHTML:
+		return TextOptions{PreserveImages: true}
+	default:
+		return TextOptions{}
+	}
+}
+
+// TextBlockType is the type of a structured text block.
+type TextBlockType int
+
+// Text block types.
+const (
+	TextBlockText TextBlockType = iota
+	TextBlockImage
+	TextBlockStruct
+	TextBlockVector
+	TextBlockGrid
+)
+
+// CharFlags is a bitmask describing how a character was drawn.
+type CharFlags int
+
+// Character flags.
+const (
+	CharStrikeout CharFlags = 1 << iota
+	CharUnderline
+	CharSynthetic
+	CharBold
+	CharFilled
+	CharStroked
+	CharClipped
+)
+
+// TextPage is the structured text of a page, in page points, with structure blocks flattened into their content.
+type TextPage struct {
+	Mediabox Rect
+	Blocks   []TextBlock
+}
+
+// TextBlock is a paragraph of text lines or an image.
+type TextBlock struct {
+	Type TextBlockType
+	BBox Rect
+	// Lines of a text block.
+	Lines []TextLine
+	// Size in pixels of the image of an image block.
+	ImageWidth, ImageHeight int
+}
+
+// TextLine is a list of spans that share a common baseline.
+type TextLine struct {
+	// Writing mode, 0 for horizontal and 1 for vertical.
+	WMode int
+	// Normalized direction of the baseline.
+	Dir   Point
+	BBox  Rect
+	Spans []TextSpan
+}
+
+// TextSpan is a run of characters drawn in the same style.
+type TextSpan struct {
+	Font string
+	Size float64
+	// sRGB color with alpha in the top 8 bits, then red, green and blue.
+	Color uint32
+	Flags CharFlags
+	BBox  Rect
+	Chars []TextChar
+}
+
+// TextChar is a single character with its position.
+type TextChar struct {
+	Rune   rune
+	Origin Point
+	Quad   Quad
+	// Bidi level, even for left-to-right and odd for right-to-left.
+	Bidi int
+}
+
+// String returns the text of the line.
+func (l TextLine) String() string {
+	var b strings.Builder
+	for _, span := range l.Spans {
+		for _, c := range span.Chars {
+			b.WriteRune(c.Rune)
+		}
+	}
+
+	return b.String()
+}
+
+// addChar appends c to the last span of l, starting a new span when the style changes.
+func (l *TextLine) addChar(c TextChar, font string, size float64, color uint32, flags CharFlags) {
+	n := len(l.Spans)
+	if n == 0 || l.Spans[n-1].Font != font || l.Spans[n-1].Size != size || l.Spans[n-1].Color != color || l.Spans[n-1].Flags != flags {
+		l.Spans = append(l.Spans, TextSpan{Font: font, Size: size, Color: color, Flags: flags, BBox: c.Quad.Rect()})
+		n++
+	}
+
+	span := &l.Spans[n-1]
+	span.BBox = span.BBox.Union(c.Quad.Rect())
+	span.Chars = append(span.Chars, c)
+}
+
+// Paragraph is a paragraph of text, in reading order.
+type Paragraph struct {
+	Text string
+	BBox Rect
+	// Region is the index of the page segment, such as a column, that contains the paragraph.
+	Region int
+	// RegionBBox is the bbox of the region.
+	RegionBBox Rect
+}
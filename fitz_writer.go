@@ -0,0 +1,60 @@
+package fitz
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+// documentFormats are the formats of the MuPDF document writers that write to an output.
+var documentFormats = map[string]bool{
+	"pdf":   true,
+	"cbz":   true,
+	"svg":   true,
+	"txt":   true,
+	"text":  true,
+	"html":  true,
+	"xhtml": true,
+	"stext": true,
+	"ps":    true,
+	"pwg":   true,
+	"pcl":   true,
+	"pclm":  true,
+	"docx":  true,
+	"odt":   true,
+	"csv":   true,
+}
+
+// Convert writes the given page numbers of src to w in format, all pages if pages is nil.
+// Format is one of pdf, cbz, svg, txt, html, xhtml, stext, ps, pwg, pcl, pclm, docx, odt or csv,
+// options is a comma separated list of writer options as accepted by mutool convert -O.
+func Convert(src *Document, w io.Writer, format string, options string, pages []int) error {
+	return ConvertContext(context.Background(), src, w, format, options, pages, nil)
+}
+
+// documentFormat returns the MuPDF name of format, and whether a document writer supports it.
+func documentFormat(format string) (string, bool) {
+	format = strings.ToLower(strings.TrimPrefix(format, "."))
+
+	return format, documentFormats[format]
+}
+
+// convertPages returns the page numbers to convert out of n, all pages if pages is nil.
+func convertPages(pages []int, n int) ([]int, error) {
+	if pages == nil {
+		pages = make([]int, n)
+		for i := range pages {
+			pages[i] = i
+		}
+
+		return pages, nil
+	}
+
+	for _, number := range pages {
+		if number < 0 || number >= n {
+			return nil, ErrPageMissing
+		}
+	}
+
+	return pages, nil
+}
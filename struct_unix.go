@@ -2,42 +2,190 @@
 
 package fitz
 
-import "github.com/ebitengine/purego"
+import (
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
 
 // Functions passing/returning MuPDF structs by value; purego handles these natively on SysV/AAPCS.
 var (
-	fzBoundPage                func(ctx *fzContext, page *fzPage) fzRect
-	fzNewDrawDevice            func(ctx *fzContext, transform fzMatrix, dest *fzPixmap) *fzDevice
-	fzRunPageContents          func(ctx *fzContext, page *fzPage, dev *fzDevice, transform fzMatrix, cookie *fzCookie)
-	fzNewBufferFromPixmapAsPNG func(ctx *fzContext, pix *fzPixmap, params fzColorParams) *fzBuffer
-	fzNewStextPage             func(ctx *fzContext, mediabox fzRect) *fzStextPage
+	fzBoundPage                 func(ctx *fzContext, page *fzPage) fzRect
+	fzBoundPageBox              func(ctx *fzContext, page *fzPage, box int) fzRect
+	fzNewDrawDevice             func(ctx *fzContext, transform fzMatrix, dest *fzPixmap) *fzDevice
+	fzRunPageContents           func(ctx *fzContext, page *fzPage, dev *fzDevice, transform fzMatrix, cookie *fzCookie)
+	fzNewBufferFromPixmapAsPNG  func(ctx *fzContext, pix *fzPixmap, params fzColorParams) *fzBuffer
+	fzNewBufferFromPixmapAsPAM  func(ctx *fzContext, pix *fzPixmap, params fzColorParams) *fzBuffer
+	fzNewBufferFromPixmapAsPNM  func(ctx *fzContext, pix *fzPixmap, params fzColorParams) *fzBuffer
+	fzNewBufferFromPixmapAsPSD  func(ctx *fzContext, pix *fzPixmap, params fzColorParams) *fzBuffer
+	fzNewBufferFromPixmapAsPKM  func(ctx *fzContext, pix *fzPixmap, params fzColorParams) *fzBuffer
+	fzNewBufferFromPixmapAsJPEG func(ctx *fzContext, pix *fzPixmap, params fzColorParams, quality, invertCmyk int) *fzBuffer
+	fzNewBufferFromPixmapAsJPX  func(ctx *fzContext, pix *fzPixmap, params fzColorParams, quality int) *fzBuffer
+	fzNewBufferFromImageAsPNG   func(ctx *fzContext, image *fzImage, params fzColorParams) *fzBuffer
+	fzNewStextPage              func(ctx *fzContext, mediabox fzRect) *fzStextPage
+	fzCopyRectangle             func(ctx *fzContext, page *fzStextPage, area fzRect, crlf int) *uint8
+	fzSnapSelection             func(ctx *fzContext, page *fzStextPage, ap, bp *fzPoint, mode int) fzQuad
+	fzHighlightSelection        func(ctx *fzContext, page *fzStextPage, a, b fzPoint, quads *fzQuad, maxQuads int) int
+	fzCopySelection             func(ctx *fzContext, page *fzStextPage, a, b fzPoint, crlf int) *uint8
+	fzResolveLinkDest           func(ctx *fzContext, doc *fzDocument, uri *uint8) fzLinkDest
+	fzPageNumberFromLocation    func(ctx *fzContext, doc *fzDocument, loc fzLocation) int32
+	fzLocationFromPageNumber    func(ctx *fzContext, doc *fzDocument, number int) fzLocation
+	fzMakeBookmark              func(ctx *fzContext, doc *fzDocument, loc fzLocation) int64
+	fzLookupBookmark            func(ctx *fzContext, doc *fzDocument, mark int64) fzLocation
+	fzNextPage                  func(ctx *fzContext, doc *fzDocument, loc fzLocation) fzLocation
+	fzPreviousPage              func(ctx *fzContext, doc *fzDocument, loc fzLocation) fzLocation
+	fzNewDisplayList            func(ctx *fzContext, mediabox fzRect) *fzDisplayList
+	fzNewPixmapWithBboxAndData  func(ctx *fzContext, colorspace *fzColorspace, bbox fzIRect, seps *fzSeparations, alpha int, samples *uint8) *fzPixmap
+	fzRunDisplayList            func(ctx *fzContext, list *fzDisplayList, dev *fzDevice, ctm fzMatrix, scissor fzRect, cookie *fzCookie)
+	fzBeginPage                 func(ctx *fzContext, wri *fzDocumentWriter, mediabox fzRect) *fzDevice
+	fzRunPage                   func(ctx *fzContext, page *fzPage, dev *fzDevice, transform fzMatrix, cookie *fzCookie)
 )
 
 func registerStructFuncs(lib uintptr) {
 	purego.RegisterLibFunc(&fzBoundPage, lib, "fz_bound_page")
+	purego.RegisterLibFunc(&fzBoundPageBox, lib, "fz_bound_page_box")
 	purego.RegisterLibFunc(&fzNewDrawDevice, lib, "fz_new_draw_device")
 	purego.RegisterLibFunc(&fzRunPageContents, lib, "fz_run_page_contents")
 	purego.RegisterLibFunc(&fzNewBufferFromPixmapAsPNG, lib, "fz_new_buffer_from_pixmap_as_png")
+	purego.RegisterLibFunc(&fzNewBufferFromPixmapAsPAM, lib, "fz_new_buffer_from_pixmap_as_pam")
+	purego.RegisterLibFunc(&fzNewBufferFromPixmapAsPNM, lib, "fz_new_buffer_from_pixmap_as_pnm")
+	purego.RegisterLibFunc(&fzNewBufferFromPixmapAsPSD, lib, "fz_new_buffer_from_pixmap_as_psd")
+	purego.RegisterLibFunc(&fzNewBufferFromPixmapAsPKM, lib, "fz_new_buffer_from_pixmap_as_pkm")
+	purego.RegisterLibFunc(&fzNewBufferFromPixmapAsJPEG, lib, "fz_new_buffer_from_pixmap_as_jpeg")
+	purego.RegisterLibFunc(&fzNewBufferFromPixmapAsJPX, lib, "fz_new_buffer_from_pixmap_as_jpx")
+	purego.RegisterLibFunc(&fzNewBufferFromImageAsPNG, lib, "fz_new_buffer_from_image_as_png")
 	purego.RegisterLibFunc(&fzNewStextPage, lib, "fz_new_stext_page")
+	purego.RegisterLibFunc(&fzCopyRectangle, lib, "fz_copy_rectangle")
+	purego.RegisterLibFunc(&fzSnapSelection, lib, "fz_snap_selection")
+	purego.RegisterLibFunc(&fzHighlightSelection, lib, "fz_highlight_selection")
+	purego.RegisterLibFunc(&fzCopySelection, lib, "fz_copy_selection")
+	purego.RegisterLibFunc(&fzResolveLinkDest, lib, "fz_resolve_link_dest")
+	purego.RegisterLibFunc(&fzPageNumberFromLocation, lib, "fz_page_number_from_location")
+	purego.RegisterLibFunc(&fzLocationFromPageNumber, lib, "fz_location_from_page_number")
+	purego.RegisterLibFunc(&fzMakeBookmark, lib, "fz_make_bookmark")
+	purego.RegisterLibFunc(&fzLookupBookmark, lib, "fz_lookup_bookmark")
+	purego.RegisterLibFunc(&fzNextPage, lib, "fz_next_page")
+	purego.RegisterLibFunc(&fzPreviousPage, lib, "fz_previous_page")
+	purego.RegisterLibFunc(&fzNewDisplayList, lib, "fz_new_display_list")
+	purego.RegisterLibFunc(&fzRunDisplayList, lib, "fz_run_display_list")
+	purego.RegisterLibFunc(&fzBeginPage, lib, "fz_begin_page")
+	purego.RegisterLibFunc(&fzRunPage, lib, "fz_run_page")
+	purego.RegisterLibFunc(&fzNewPixmapWithBboxAndData, lib, "fz_new_pixmap_with_bbox_and_data")
 }
 
 func boundPage(ctx *fzContext, page *fzPage) fzRect {
 	return fzBoundPage(ctx, page)
 }
 
+func boundPageBox(ctx *fzContext, page *fzPage, box int) fzRect {
+	return fzBoundPageBox(ctx, page, box)
+}
+
 func newDrawDevice(ctx *fzContext, transform fzMatrix, dest *fzPixmap) *fzDevice {
 	return fzNewDrawDevice(ctx, transform, dest)
 }
 
 func runPageContents(ctx *fzContext, page *fzPage, dev *fzDevice, transform fzMatrix) {
 	var cookie fzCookie
-	fzRunPageContents(ctx, page, dev, transform, &cookie)
+	runPageContentsWithCookie(ctx, page, dev, transform, &cookie)
+}
+
+func runPageContentsWithCookie(ctx *fzContext, page *fzPage, dev *fzDevice, transform fzMatrix, cookie *fzCookie) {
+	fzRunPageContents(ctx, page, dev, transform, cookie)
 }
 
-func newBufferFromPixmapAsPNG(ctx *fzContext, pix *fzPixmap, params fzColorParams) *fzBuffer {
+func newBufferFromPixmap(ctx *fzContext, pix *fzPixmap, format ImageFormat, params fzColorParams, quality int) *fzBuffer {
+	switch format {
+	case ImageFormatPAM:
+		return fzNewBufferFromPixmapAsPAM(ctx, pix, params)
+	case ImageFormatJPEG:
+		return fzNewBufferFromPixmapAsJPEG(ctx, pix, params, quality, 0)
+	case ImageFormatPNM:
+		return fzNewBufferFromPixmapAsPNM(ctx, pix, params)
+	case ImageFormatPSD:
+		return fzNewBufferFromPixmapAsPSD(ctx, pix, params)
+	case ImageFormatJPX:
+		return fzNewBufferFromPixmapAsJPX(ctx, pix, params, quality)
+	case ImageFormatPKM:
+		return fzNewBufferFromPixmapAsPKM(ctx, pix, params)
+	}
+
 	return fzNewBufferFromPixmapAsPNG(ctx, pix, params)
 }
 
+func newBufferFromImageAsPNG(ctx *fzContext, image *fzImage, params fzColorParams) *fzBuffer {
+	return fzNewBufferFromImageAsPNG(ctx, image, params)
+}
+
+// imageColorspace returns the colorspace of an image; GCC packs the bit-fields of fz_image after bpc, so colorspace is at offset 64.
+func imageColorspace(image *fzImage) *fzColorspace {
+	return *(**fzColorspace)(unsafe.Add(unsafe.Pointer(image), 64))
+}
+
 func newStextPage(ctx *fzContext, mediabox fzRect) *fzStextPage {
 	return fzNewStextPage(ctx, mediabox)
 }
+
+func copyRectangle(ctx *fzContext, page *fzStextPage, area fzRect) *uint8 {
+	return fzCopyRectangle(ctx, page, area, 0)
+}
+
+func snapSelection(ctx *fzContext, page *fzStextPage, ap, bp *fzPoint, mode int) {
+	fzSnapSelection(ctx, page, ap, bp, mode)
+}
+
+func highlightSelection(ctx *fzContext, page *fzStextPage, a, b fzPoint, quads []fzQuad) int {
+	return fzHighlightSelection(ctx, page, a, b, unsafe.SliceData(quads), len(quads))
+}
+
+func copySelection(ctx *fzContext, page *fzStextPage, a, b fzPoint) *uint8 {
+	return fzCopySelection(ctx, page, a, b, 0)
+}
+
+func resolveLinkDest(ctx *fzContext, doc *fzDocument, uri *uint8) fzLinkDest {
+	return fzResolveLinkDest(ctx, doc, uri)
+}
+
+func pageNumberFromLocation(ctx *fzContext, doc *fzDocument, loc fzLocation) int {
+	return int(fzPageNumberFromLocation(ctx, doc, loc))
+}
+
+func makeBookmark(ctx *fzContext, doc *fzDocument, number int) int64 {
+	return fzMakeBookmark(ctx, doc, fzLocationFromPageNumber(ctx, doc, number))
+}
+
+func lookupBookmark(ctx *fzContext, doc *fzDocument, mark int64) fzLocation {
+	return fzLookupBookmark(ctx, doc, mark)
+}
+
+func locationFromPageNumber(ctx *fzContext, doc *fzDocument, number int) fzLocation {
+	return fzLocationFromPageNumber(ctx, doc, number)
+}
+
+func nextPage(ctx *fzContext, doc *fzDocument, loc fzLocation) fzLocation {
+	return fzNextPage(ctx, doc, loc)
+}
+
+func previousPage(ctx *fzContext, doc *fzDocument, loc fzLocation) fzLocation {
+	return fzPreviousPage(ctx, doc, loc)
+}
+
+func newDisplayList(ctx *fzContext, mediabox fzRect) *fzDisplayList {
+	return fzNewDisplayList(ctx, mediabox)
+}
+
+func newPixmapWithBboxAndData(ctx *fzContext, colorspace *fzColorspace, bbox fzIRect, alpha int, samples *uint8) *fzPixmap {
+	return fzNewPixmapWithBboxAndData(ctx, colorspace, bbox, nil, alpha, samples)
+}
+
+func runDisplayList(ctx *fzContext, list *fzDisplayList, dev *fzDevice, ctm fzMatrix, scissor fzRect, cookie *fzCookie) {
+	fzRunDisplayList(ctx, list, dev, ctm, scissor, cookie)
+}
+
+func beginPage(ctx *fzContext, wri *fzDocumentWriter, mediabox fzRect) *fzDevice {
+	return fzBeginPage(ctx, wri, mediabox)
+}
+
+func runPage(ctx *fzContext, page *fzPage, dev *fzDevice, transform fzMatrix, cookie *fzCookie) {
+	fzRunPage(ctx, page, dev, transform, cookie)
+}
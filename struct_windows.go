@@ -2,24 +2,77 @@
 
 package fitz
 
-import "github.com/ebitengine/purego"
+import (
+	"math"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
 
 // Windows x64 passes >8-byte structs by pointer and returns them via sret, so by-value
-// params are pointers (fz_bound_page gets a leading sret, fz_color_params packs into a uint32).
+// params are pointers (fz_bound_page gets a leading sret, fz_color_params packs into a uint32, fz_point and fz_location into a uint64).
 var (
-	fzBoundPage                func(sret *fzRect, ctx *fzContext, page *fzPage) uintptr
-	fzNewDrawDevice            func(ctx *fzContext, transform *fzMatrix, dest *fzPixmap) *fzDevice
-	fzRunPageContents          func(ctx *fzContext, page *fzPage, dev *fzDevice, transform *fzMatrix, cookie *fzCookie)
-	fzNewBufferFromPixmapAsPNG func(ctx *fzContext, pix *fzPixmap, params uint32) *fzBuffer
-	fzNewStextPage             func(ctx *fzContext, mediabox *fzRect) *fzStextPage
+	fzBoundPage                 func(sret *fzRect, ctx *fzContext, page *fzPage) uintptr
+	fzBoundPageBox              func(sret *fzRect, ctx *fzContext, page *fzPage, box int) uintptr
+	fzNewDrawDevice             func(ctx *fzContext, transform *fzMatrix, dest *fzPixmap) *fzDevice
+	fzRunPageContents           func(ctx *fzContext, page *fzPage, dev *fzDevice, transform *fzMatrix, cookie *fzCookie)
+	fzNewBufferFromPixmapAsPNG  func(ctx *fzContext, pix *fzPixmap, params uint32) *fzBuffer
+	fzNewBufferFromPixmapAsPAM  func(ctx *fzContext, pix *fzPixmap, params uint32) *fzBuffer
+	fzNewBufferFromPixmapAsPNM  func(ctx *fzContext, pix *fzPixmap, params uint32) *fzBuffer
+	fzNewBufferFromPixmapAsPSD  func(ctx *fzContext, pix *fzPixmap, params uint32) *fzBuffer
+	fzNewBufferFromPixmapAsPKM  func(ctx *fzContext, pix *fzPixmap, params uint32) *fzBuffer
+	fzNewBufferFromPixmapAsJPEG func(ctx *fzContext, pix *fzPixmap, params uint32, quality, invertCmyk int) *fzBuffer
+	fzNewBufferFromPixmapAsJPX  func(ctx *fzContext, pix *fzPixmap, params uint32, quality int) *fzBuffer
+	fzNewBufferFromImageAsPNG   func(ctx *fzContext, image *fzImage, params uint32) *fzBuffer
+	fzNewStextPage              func(ctx *fzContext, mediabox *fzRect) *fzStextPage
+	fzCopyRectangle             func(ctx *fzContext, page *fzStextPage, area *fzRect, crlf int) *uint8
+	fzSnapSelection             func(sret *fzQuad, ctx *fzContext, page *fzStextPage, ap, bp *fzPoint, mode int) uintptr
+	fzHighlightSelection        func(ctx *fzContext, page *fzStextPage, a, b uint64, quads *fzQuad, maxQuads int) int
+	fzCopySelection             func(ctx *fzContext, page *fzStextPage, a, b uint64, crlf int) *uint8
+	fzResolveLinkDest           func(sret *fzLinkDest, ctx *fzContext, doc *fzDocument, uri *uint8) uintptr
+	fzPageNumberFromLocation    func(ctx *fzContext, doc *fzDocument, loc uint64) int32
+	fzLocationFromPageNumber    func(ctx *fzContext, doc *fzDocument, number int) uint64
+	fzMakeBookmark              func(ctx *fzContext, doc *fzDocument, loc uint64) int64
+	fzLookupBookmark            func(ctx *fzContext, doc *fzDocument, mark int64) uint64
+	fzNextPage                  func(ctx *fzContext, doc *fzDocument, loc uint64) uint64
+	fzPreviousPage              func(ctx *fzContext, doc *fzDocument, loc uint64) uint64
+	fzNewDisplayList            func(ctx *fzContext, mediabox *fzRect) *fzDisplayList
+	fzNewPixmapWithBboxAndData  func(ctx *fzContext, colorspace *fzColorspace, bbox *fzIRect, seps *fzSeparations, alpha int, samples *uint8) *fzPixmap
+	fzRunDisplayList            func(ctx *fzContext, list *fzDisplayList, dev *fzDevice, ctm *fzMatrix, scissor *fzRect, cookie *fzCookie)
+	fzBeginPage                 func(ctx *fzContext, wri *fzDocumentWriter, mediabox *fzRect) *fzDevice
+	fzRunPage                   func(ctx *fzContext, page *fzPage, dev *fzDevice, transform *fzMatrix, cookie *fzCookie)
 )
 
 func registerStructFuncs(lib uintptr) {
 	purego.RegisterLibFunc(&fzBoundPage, lib, "fz_bound_page")
+	purego.RegisterLibFunc(&fzBoundPageBox, lib, "fz_bound_page_box")
 	purego.RegisterLibFunc(&fzNewDrawDevice, lib, "fz_new_draw_device")
 	purego.RegisterLibFunc(&fzRunPageContents, lib, "fz_run_page_contents")
 	purego.RegisterLibFunc(&fzNewBufferFromPixmapAsPNG, lib, "fz_new_buffer_from_pixmap_as_png")
+	purego.RegisterLibFunc(&fzNewBufferFromPixmapAsPAM, lib, "fz_new_buffer_from_pixmap_as_pam")
+	purego.RegisterLibFunc(&fzNewBufferFromPixmapAsPNM, lib, "fz_new_buffer_from_pixmap_as_pnm")
+	purego.RegisterLibFunc(&fzNewBufferFromPixmapAsPSD, lib, "fz_new_buffer_from_pixmap_as_psd")
+	purego.RegisterLibFunc(&fzNewBufferFromPixmapAsPKM, lib, "fz_new_buffer_from_pixmap_as_pkm")
+	purego.RegisterLibFunc(&fzNewBufferFromPixmapAsJPEG, lib, "fz_new_buffer_from_pixmap_as_jpeg")
+	purego.RegisterLibFunc(&fzNewBufferFromPixmapAsJPX, lib, "fz_new_buffer_from_pixmap_as_jpx")
+	purego.RegisterLibFunc(&fzNewBufferFromImageAsPNG, lib, "fz_new_buffer_from_image_as_png")
 	purego.RegisterLibFunc(&fzNewStextPage, lib, "fz_new_stext_page")
+	purego.RegisterLibFunc(&fzCopyRectangle, lib, "fz_copy_rectangle")
+	purego.RegisterLibFunc(&fzSnapSelection, lib, "fz_snap_selection")
+	purego.RegisterLibFunc(&fzHighlightSelection, lib, "fz_highlight_selection")
+	purego.RegisterLibFunc(&fzCopySelection, lib, "fz_copy_selection")
+	purego.RegisterLibFunc(&fzResolveLinkDest, lib, "fz_resolve_link_dest")
+	purego.RegisterLibFunc(&fzPageNumberFromLocation, lib, "fz_page_number_from_location")
+	purego.RegisterLibFunc(&fzLocationFromPageNumber, lib, "fz_location_from_page_number")
+	purego.RegisterLibFunc(&fzMakeBookmark, lib, "fz_make_bookmark")
+	purego.RegisterLibFunc(&fzLookupBookmark, lib, "fz_lookup_bookmark")
+	purego.RegisterLibFunc(&fzNextPage, lib, "fz_next_page")
+	purego.RegisterLibFunc(&fzPreviousPage, lib, "fz_previous_page")
+	purego.RegisterLibFunc(&fzNewDisplayList, lib, "fz_new_display_list")
+	purego.RegisterLibFunc(&fzRunDisplayList, lib, "fz_run_display_list")
+	purego.RegisterLibFunc(&fzBeginPage, lib, "fz_begin_page")
+	purego.RegisterLibFunc(&fzRunPage, lib, "fz_run_page")
+	purego.RegisterLibFunc(&fzNewPixmapWithBboxAndData, lib, "fz_new_pixmap_with_bbox_and_data")
 }
 
 func boundPage(ctx *fzContext, page *fzPage) fzRect {
@@ -29,21 +82,138 @@ func boundPage(ctx *fzContext, page *fzPage) fzRect {
 	return ret
 }
 
+func boundPageBox(ctx *fzContext, page *fzPage, box int) fzRect {
+	var ret fzRect
+	fzBoundPageBox(&ret, ctx, page, box)
+
+	return ret
+}
+
 func newDrawDevice(ctx *fzContext, transform fzMatrix, dest *fzPixmap) *fzDevice {
 	return fzNewDrawDevice(ctx, &transform, dest)
 }
 
 func runPageContents(ctx *fzContext, page *fzPage, dev *fzDevice, transform fzMatrix) {
 	var cookie fzCookie
-	fzRunPageContents(ctx, page, dev, &transform, &cookie)
+	runPageContentsWithCookie(ctx, page, dev, transform, &cookie)
 }
 
-func newBufferFromPixmapAsPNG(ctx *fzContext, pix *fzPixmap, params fzColorParams) *fzBuffer {
+func runPageContentsWithCookie(ctx *fzContext, page *fzPage, dev *fzDevice, transform fzMatrix, cookie *fzCookie) {
+	fzRunPageContents(ctx, page, dev, &transform, cookie)
+}
+
+func newBufferFromPixmap(ctx *fzContext, pix *fzPixmap, format ImageFormat, params fzColorParams, quality int) *fzBuffer {
 	packed := uint32(params.Ri) | uint32(params.Bp)<<8 | uint32(params.Op)<<16 | uint32(params.Opm)<<24
 
+	switch format {
+	case ImageFormatPAM:
+		return fzNewBufferFromPixmapAsPAM(ctx, pix, packed)
+	case ImageFormatJPEG:
+		return fzNewBufferFromPixmapAsJPEG(ctx, pix, packed, quality, 0)
+	case ImageFormatPNM:
+		return fzNewBufferFromPixmapAsPNM(ctx, pix, packed)
+	case ImageFormatPSD:
+		return fzNewBufferFromPixmapAsPSD(ctx, pix, packed)
+	case ImageFormatJPX:
+		return fzNewBufferFromPixmapAsJPX(ctx, pix, packed, quality)
+	case ImageFormatPKM:
+		return fzNewBufferFromPixmapAsPKM(ctx, pix, packed)
+	}
+
 	return fzNewBufferFromPixmapAsPNG(ctx, pix, packed)
 }
 
+func newBufferFromImageAsPNG(ctx *fzContext, image *fzImage, params fzColorParams) *fzBuffer {
+	packed := uint32(params.Ri) | uint32(params.Bp)<<8 | uint32(params.Op)<<16 | uint32(params.Opm)<<24
+
+	return fzNewBufferFromImageAsPNG(ctx, image, packed)
+}
+
+// imageColorspace returns the colorspace of an image; MSVC starts the bit-fields of fz_image in a new unsigned int, so colorspace is at offset 72.
+func imageColorspace(image *fzImage) *fzColorspace {
+	return *(**fzColorspace)(unsafe.Add(unsafe.Pointer(image), 72))
+}
+
 func newStextPage(ctx *fzContext, mediabox fzRect) *fzStextPage {
 	return fzNewStextPage(ctx, &mediabox)
 }
+
+func copyRectangle(ctx *fzContext, page *fzStextPage, area fzRect) *uint8 {
+	return fzCopyRectangle(ctx, page, &area, 0)
+}
+
+func snapSelection(ctx *fzContext, page *fzStextPage, ap, bp *fzPoint, mode int) {
+	var ret fzQuad
+	fzSnapSelection(&ret, ctx, page, ap, bp, mode)
+}
+
+func highlightSelection(ctx *fzContext, page *fzStextPage, a, b fzPoint, quads []fzQuad) int {
+	return fzHighlightSelection(ctx, page, packPoint(a), packPoint(b), unsafe.SliceData(quads), len(quads))
+}
+
+func copySelection(ctx *fzContext, page *fzStextPage, a, b fzPoint) *uint8 {
+	return fzCopySelection(ctx, page, packPoint(a), packPoint(b), 0)
+}
+
+func resolveLinkDest(ctx *fzContext, doc *fzDocument, uri *uint8) fzLinkDest {
+	var ret fzLinkDest
+	fzResolveLinkDest(&ret, ctx, doc, uri)
+
+	return ret
+}
+
+func pageNumberFromLocation(ctx *fzContext, doc *fzDocument, loc fzLocation) int {
+	return int(fzPageNumberFromLocation(ctx, doc, packLocation(loc)))
+}
+
+func makeBookmark(ctx *fzContext, doc *fzDocument, number int) int64 {
+	return fzMakeBookmark(ctx, doc, fzLocationFromPageNumber(ctx, doc, number))
+}
+
+func lookupBookmark(ctx *fzContext, doc *fzDocument, mark int64) fzLocation {
+	return unpackLocation(fzLookupBookmark(ctx, doc, mark))
+}
+
+func locationFromPageNumber(ctx *fzContext, doc *fzDocument, number int) fzLocation {
+	return unpackLocation(fzLocationFromPageNumber(ctx, doc, number))
+}
+
+func nextPage(ctx *fzContext, doc *fzDocument, loc fzLocation) fzLocation {
+	return unpackLocation(fzNextPage(ctx, doc, packLocation(loc)))
+}
+
+func previousPage(ctx *fzContext, doc *fzDocument, loc fzLocation) fzLocation {
+	return unpackLocation(fzPreviousPage(ctx, doc, packLocation(loc)))
+}
+
+func newDisplayList(ctx *fzContext, mediabox fzRect) *fzDisplayList {
+	return fzNewDisplayList(ctx, &mediabox)
+}
+
+func newPixmapWithBboxAndData(ctx *fzContext, colorspace *fzColorspace, bbox fzIRect, alpha int, samples *uint8) *fzPixmap {
+	return fzNewPixmapWithBboxAndData(ctx, colorspace, &bbox, nil, alpha, samples)
+}
+
+func runDisplayList(ctx *fzContext, list *fzDisplayList, dev *fzDevice, ctm fzMatrix, scissor fzRect, cookie *fzCookie) {
+	fzRunDisplayList(ctx, list, dev, &ctm, &scissor, cookie)
+}
+
+func packPoint(p fzPoint) uint64 {
+	return uint64(math.Float32bits(p.X)) | uint64(math.Float32bits(p.Y))<<32
+}
+
+func packLocation(loc fzLocation) uint64 {
+	return uint64(uint32(loc.Chapter)) | uint64(uint32(loc.Page))<<32
+}
+
+func unpackLocation(loc uint64) fzLocation {
+	return fzLocation{Chapter: int32(uint32(loc)), Page: int32(uint32(loc >> 32))}
+}
+
+func beginPage(ctx *fzContext, wri *fzDocumentWriter, mediabox fzRect) *fzDevice {
+	return fzBeginPage(ctx, wri, &mediabox)
+}
+
+func runPage(ctx *fzContext, page *fzPage, dev *fzDevice, transform fzMatrix, cookie *fzCookie) {
+	fzRunPage(ctx, page, dev, &transform, cookie)
+}